@@ -2,35 +2,41 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"net/http"
-	"os"
+	"log/slog"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
 	metricName = "realtime_traffic"
-	metricHelp = "Realtime traffic report"
+	metricHelp = "Realtime traffic in the current report window"
+
+	// defaultTimelineMetric is requested by default. The API reports its
+	// values as realtimeTraffic, which is exported by trafficGauge.
+	defaultTimelineMetric = "realtimeRequests"
+
+	timelineTimeLayout = "2006-01-02T15:04:05"
 )
 
+var timelineAPIIntervals = []int{30, 60, 300, 600, 900, 1800, 3600, 86400}
+
 var (
-	trafficCounter = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: "ngenix",
-			Subsystem: "realtime",
-			Name:      metricName,
-			Help:      metricHelp,
-		},
-		[]string{"httpStatus", "realtimeTraffic"},
-	)
-	mu sync.Mutex
+	trafficGauge           *prometheus.GaugeVec
+	trafficMax             *prometheus.GaugeVec
+	trafficMin             *prometheus.GaugeVec
+	trafficAvg             *prometheus.GaugeVec
+	httpStatusDescription  *prometheus.GaugeVec
+	timelineEmptyResponses *prometheus.CounterVec
+	timelineGauges         map[string]*prometheus.GaugeVec
 )
 
 type Report struct {
@@ -78,9 +84,7 @@ type Report struct {
 				HTTPStatus int    `json:"httpStatus"`
 				ModelName  string `json:"modelName"`
 			} `json:"groupedBy"`
-			Metrics struct {
-				RealtimeTraffic int `json:"realtimeTraffic"`
-			} `json:"metrics"`
+			Metrics map[string]any `json:"metrics"`
 		} `json:"values"`
 		ModelName string `json:"modelName"`
 	} `json:"data"`
@@ -102,87 +106,388 @@ type Report struct {
 	ModelName string `json:"modelName"`
 }
 
-func init() {
-	prometheus.MustRegister(trafficCounter)
-}
+func setupTimelineMetrics(metrics []string) error {
+	trafficGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      metricName,
+			Help:      metricHelp,
+		},
+		[]string{"configId", "config_name", "httpStatus", "model_name"},
+	)
+	trafficMax = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "traffic_max",
+			Help:      "Maximum realtime traffic in the report window",
+		},
+		[]string{"configId", "config_name", "httpStatus"},
+	)
+	trafficMin = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "traffic_min",
+			Help:      "Minimum realtime traffic in the report window",
+		},
+		[]string{"configId", "config_name", "httpStatus"},
+	)
+	trafficAvg = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "traffic_avg",
+			Help:      "Average realtime traffic in the report window",
+		},
+		[]string{"configId", "config_name", "httpStatus"},
+	)
+	httpStatusDescription = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "http_status_description_info",
+			Help:      "Human-readable description of HTTP status codes reported by NGENIX",
+		},
+		[]string{"code", "description"},
+	)
+	timelineEmptyResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Name:      "timeline_empty_responses_total",
+			Help:      "Total number of successful timeline responses without data",
+		},
+		[]string{"configId", "config_name"},
+	)
+	timelineGauges = map[string]*prometheus.GaugeVec{
+		"realtimeTraffic": trafficGauge,
+	}
 
-func fetchReport() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+	registry.MustRegister(trafficGauge)
+	registry.MustRegister(trafficMax)
+	registry.MustRegister(trafficMin)
+	registry.MustRegister(trafficAvg)
+	registry.MustRegister(httpStatusDescription)
+	registry.MustRegister(timelineEmptyResponses)
 
-	for range ticker.C {
-		var report Report
-		if err := fetchData(&report); err != nil {
-			log.Printf("error fetching data: %v", err)
+	for _, metric := range metrics {
+		if _, ok := timelineGauges[metric]; ok || metric == defaultTimelineMetric {
 			continue
 		}
+		if !metricNamePattern.MatchString(metric) {
+			return fmt.Errorf("invalid timeline metric %q", metric)
+		}
 
-		processReport(&report)
+		gauge := prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: *metricsNamespace,
+				Subsystem: *metricsSubsystem,
+				Name:      timelineMetricName(metric),
+				Help:      fmt.Sprintf("Timeline %s in the current report window", metric),
+			},
+			[]string{"configId", "config_name", "httpStatus", "model_name"},
+		)
+		if err := registry.Register(gauge); err != nil {
+			return fmt.Errorf("timeline metric %q: %w", metric, err)
+		}
+		timelineGauges[metric] = gauge
 	}
-}
 
-func fetchData(report *Report) error {
-	log.Println("Fetching data from NGENIX API")
+	return nil
+}
 
-	username, password := os.Getenv("NGENIX_USERNAME"), os.Getenv("NGENIX_PASSWORD")
-	if username == "" || password == "" {
-		return errors.New("missing basic auth credentials")
+// timelineMetricName drops the realtime prefix that every timeline metric
+// shares, so realtimeBandwidth is exported as ngenix_realtime_bandwidth
+// rather than ngenix_realtime_realtime_bandwidth.
+func timelineMetricName(metric string) string {
+	if rest, ok := strings.CutPrefix(metric, "realtime"); ok && rest != "" && unicode.IsUpper(rune(rest[0])) {
+		metric = rest
 	}
 
-	configID := os.Getenv("NGENIX_CONFIG_ID")
-	if configID == "" {
-		return errors.New("missing config id")
+	return toSnakeCase(metric)
+}
+
+// toSnakeCase converts camelCase to snake_case and keeps runs of capitals
+// together, so cacheHitRatioURL becomes cache_hit_ratio_url.
+func toSnakeCase(s string) string {
+	runes := []rune(s)
+
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && !unicode.IsUpper(runes[i-1])
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
 	}
 
-	url := buildReportURL(configID, time.Now())
-	log.Printf("Fetching data from URL: %s", url)
+	return b.String()
+}
+
+type timelineCollector struct {
+	configIDs []string
+
+	mu           sync.Mutex
+	descriptions map[int]string
+	seenSeries   map[string]*labelTracker
+}
+
+func (c *timelineCollector) Collect(ctx context.Context) error {
+	return forEachConfig(ctx, c.configIDs, func(configID string) error {
+		var report Report
+		if err := fetchData(ctx, configID, &report); err != nil {
+			slog.Error("Error fetching data", "collector", collectorTimeline, "configId", configID, "err", err)
+			gauges := []*prometheus.GaugeVec{trafficMax, trafficMin, trafficAvg}
+			for _, gauge := range timelineGauges {
+				gauges = append(gauges, gauge)
+			}
+			deleteConfigSeries(collectorTimeline, configID, gauges...)
+			c.resetSeries(configID)
+			return fmt.Errorf("config %s: %w", configID, err)
+		}
+		status.recordSuccess(collectorTimeline, time.Now())
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+		if len(report.Data) == 0 {
+			slog.Warn("Report data is empty", "collector", collectorTimeline, "configId", configID)
+			timelineEmptyResponses.WithLabelValues(configID, configName(configID)).Inc()
+		}
+
+		c.updateDescriptions(&report)
+		// The reports API always returns the per-interval data, so
+		// summary-only mode saves processing but not payload.
+		if !*timelineSummaryOnly {
+			c.processReport(configID, &report)
+		}
+		c.processSummary(configID, &report)
+
+		return nil
+	})
+}
+
+func fetchData(ctx context.Context, configID string, report *Report) error {
+	start, end := timelineRange(time.Now())
+	key := cacheKey{collectorTimeline, configID, end.Truncate(time.Duration(*timelineAPIInterval) * time.Second).Format(timelineTimeLayout)}
+	if cached, ok := apiCache.get(key); ok {
+		*report = cached.(Report)
+		return nil
+	}
+
+	url, err := buildReportURL(configID, start, end, splitList(*timelineMetrics), *timelineAPIInterval, "httpStatus")
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return err
 	}
-	req.SetBasicAuth(username, password)
+	recordQueryWindow(collectorTimeline, start, end)
+
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(ctx, collectorTimeline, url)
 	if err != nil {
-		return fmt.Errorf("error executing request: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	if err := decodeResponse(resp, collectorTimeline, report); err != nil {
+		return err
+	}
+
+	apiCache.set(key, *report)
+	return nil
+}
+
+// timelineRange returns the backfill range or the rolling window ending
+// -timeline.end-offset before now.
+func timelineRange(now time.Time) (time.Time, time.Time) {
+	if backfill() {
+		return queryStart, queryEnd
 	}
 
-	log.Println("Decoding JSON response")
-	return json.NewDecoder(resp.Body).Decode(report)
+	end := now.Add(-*timelineEndOffset)
+	return end.Add(-*timelineWindow), end
 }
 
-func buildReportURL(configID string, date time.Time) string {
-	return fmt.Sprintf("https://api.ngenix.net/reports/v1/timeline/configs?configId=%s&start=%s&end=%s&metrics=realtimeRequests&interval=30&groupBy=httpStatus",
+func buildReportURL(configID string, start, end time.Time, metrics []string, interval int, groupBy string) (string, error) {
+	if err := validateConfigID(configID); err != nil {
+		return "", err
+	}
+	if start.IsZero() || end.IsZero() || !start.Before(end) {
+		return "", fmt.Errorf("invalid report window: %s - %s", start, end)
+	}
+	if len(metrics) == 0 {
+		return "", errors.New("missing metrics")
+	}
+
+	escaped := make([]string, len(metrics))
+	for i, metric := range metrics {
+		escaped[i] = url.QueryEscape(metric)
+	}
+
+	return fmt.Sprintf("%s/reports/%s/timeline/configs?configId=%s&start=%s&end=%s&metrics=%s&interval=%d&groupBy=%s",
+		apiBaseURL,
+		*apiVersion,
 		configID,
-		date.Format("2006-01-02")+"T09:00:00",
-		date.Format("2006-01-02")+"T09:59:59")
+		start.In(apiLocation).Format(*apiTimeLayout),
+		end.In(apiLocation).Format(*apiTimeLayout),
+		strings.Join(escaped, ","),
+		interval,
+		groupBy), nil
+}
+
+func validTimelineAPIInterval(interval int) bool {
+	return slices.Contains(timelineAPIIntervals, interval)
 }
 
-func processReport(report *Report) {
-	log.Println("Processing report")
+func (c *timelineCollector) processReport(configID string, report *Report) {
+	slog.Debug("Processing report", "collector", collectorTimeline, "configId", configID)
 
 	if report == nil {
-		log.Println("warning: report is nil")
+		slog.Warn("Report is nil", "collector", collectorTimeline, "configId", configID)
 		return
 	}
 
-	if report.Data == nil {
-		log.Println("warning: report.Data is nil")
-		return
-	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	mu.Lock()
-	defer mu.Unlock()
+	type seriesKey struct {
+		metric, httpStatus, modelName string
+	}
+	totals := make(map[seriesKey]float64)
 
 	for _, data := range report.Data {
 		for _, value := range data.Values {
-			trafficCounter.WithLabelValues(strconv.Itoa(value.GroupedBy.HTTPStatus)).Add(float64(value.Metrics.RealtimeTraffic))
+			if *timelineDropZeroStatus && value.GroupedBy.HTTPStatus == 0 {
+				continue
+			}
+
+			httpStatus := strconv.Itoa(value.GroupedBy.HTTPStatus)
+			for name, metric := range value.Metrics {
+				if _, ok := timelineGauges[name]; !ok {
+					continue
+				}
+
+				if v, ok := metric.(float64); ok {
+					totals[seriesKey{name, httpStatus, modelLabel(value.GroupedBy.ModelName)}] += v
+				}
+			}
 		}
 	}
+
+	current := make(map[string]map[string]struct{}, len(timelineGauges))
+	for metric := range timelineGauges {
+		current[metric] = make(map[string]struct{})
+	}
+	for key, total := range totals {
+		setSmoothed(timelineGauges[key.metric], total, configID, configName(configID), key.httpStatus, key.modelName)
+		current[key.metric][timelineSeriesLabel(key.httpStatus, key.modelName)] = struct{}{}
+	}
+	for metric, labels := range current {
+		c.tracker(metric).evictMissing(configID, "", labels)
+	}
+}
+
+// tracker returns the label tracker of a timeline gauge. Its series are
+// keyed by httpStatus and model_name, so the tracker model is left empty.
+func (c *timelineCollector) tracker(metric string) *labelTracker {
+	if c.seenSeries == nil {
+		c.seenSeries = make(map[string]*labelTracker)
+	}
+
+	t, ok := c.seenSeries[metric]
+	if !ok {
+		t = newLabelTracker(timelineGauges[metric])
+		t.labelValues = timelineLabelValues
+		c.seenSeries[metric] = t
+	}
+
+	return t
+}
+
+func (c *timelineCollector) resetSeries(configID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, t := range c.seenSeries {
+		t.reset(configID)
+	}
+}
+
+func timelineSeriesLabel(httpStatus, modelName string) string {
+	return httpStatus + "/" + modelName
+}
+
+func timelineLabelValues(configID, _, label string) []string {
+	httpStatus, modelName, _ := strings.Cut(label, "/")
+	return []string{configID, configName(configID), httpStatus, modelName}
+}
+
+func (c *timelineCollector) updateDescriptions(report *Report) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.descriptions == nil {
+		c.descriptions = make(map[int]string)
+	}
+
+	for code, description := range httpStatusDescriptions(report) {
+		if description == "" {
+			continue
+		}
+		if previous, ok := c.descriptions[code]; ok && previous != description {
+			httpStatusDescription.DeleteLabelValues(strconv.Itoa(code), previous)
+		}
+		httpStatusDescription.WithLabelValues(strconv.Itoa(code), description).Set(1)
+		c.descriptions[code] = description
+	}
+}
+
+func httpStatusDescriptions(report *Report) map[int]string {
+	d := report.GroupedByValuesDescription.HTTPStatus
+	return map[int]string{
+		101: d.Num101,
+		200: d.Num200,
+		201: d.Num201,
+		202: d.Num202,
+		204: d.Num204,
+		301: d.Num301,
+		302: d.Num302,
+		400: d.Num400,
+		401: d.Num401,
+		403: d.Num403,
+		404: d.Num404,
+		405: d.Num405,
+		406: d.Num406,
+		409: d.Num409,
+		410: d.Num410,
+		413: d.Num413,
+		415: d.Num415,
+		500: d.Num500,
+		502: d.Num502,
+		503: d.Num503,
+		504: d.Num504,
+	}
+}
+
+func (c *timelineCollector) processSummary(configID string, report *Report) {
+	if report == nil || len(report.Summary) == 0 {
+		slog.Warn("Report summary is empty", "collector", collectorTimeline, "configId", configID)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, summary := range report.Summary {
+		if *timelineDropZeroStatus && summary.GroupedBy.HTTPStatus == 0 {
+			continue
+		}
+
+		httpStatus := strconv.Itoa(summary.GroupedBy.HTTPStatus)
+		trafficMax.WithLabelValues(configID, configName(configID), httpStatus).Set(float64(summary.Metrics.RealtimeTraffic.Max))
+		trafficMin.WithLabelValues(configID, configName(configID), httpStatus).Set(float64(summary.Metrics.RealtimeTraffic.Min))
+		trafficAvg.WithLabelValues(configID, configName(configID), httpStatus).Set(summary.Metrics.RealtimeTraffic.Avg)
+	}
 }
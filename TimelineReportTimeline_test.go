@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadReport(t *testing.T, file string) *Report {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	return &report
+}
+
+func TestDropZeroStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		drop        bool
+		wantTraffic map[string]float64
+		wantMax     map[string]float64
+	}{
+		{
+			name:        "drop",
+			drop:        true,
+			wantTraffic: map[string]float64{"1,1,200,cdn": 150, "1,1,404,cdn": 7},
+			wantMax:     map[string]float64{"1,1,200": 100},
+		},
+		{
+			name:        "keep",
+			drop:        false,
+			wantTraffic: map[string]float64{"1,1,200,cdn": 150, "1,1,404,cdn": 7, "1,1,0,cdn": 3},
+			wantMax:     map[string]float64{"1,1,200": 100, "1,1,0": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMetrics(t, trafficGauge, trafficMax, trafficMin, trafficAvg)
+			setFlag(t, timelineDropZeroStatus, tt.drop)
+
+			report := loadReport(t, "timeline.json")
+			c := &timelineCollector{}
+			c.processReport("1", report)
+			c.processSummary("1", report)
+
+			assertSeries(t, trafficGauge, tt.wantTraffic)
+			assertSeries(t, trafficMax, tt.wantMax)
+		})
+	}
+}
+
+func TestBuildReportURL(t *testing.T) {
+	setFlag(t, &apiBaseURL, "https://api.ngenix.net")
+
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		metrics  []string
+		interval int
+		groupBy  string
+		want     string
+	}{
+		{
+			name:     "httpStatus",
+			metrics:  []string{"realtimeTraffic"},
+			interval: 30,
+			groupBy:  "httpStatus",
+			want:     "https://api.ngenix.net/reports/v1/timeline/configs?configId=123&start=2024-05-01T09:00:00&end=2024-05-01T09:59:59&metrics=realtimeTraffic&interval=30&groupBy=httpStatus",
+		},
+		{
+			name:     "cacheStatus",
+			metrics:  []string{"realtimeRequests", "realtimeTraffic"},
+			interval: 60,
+			groupBy:  "cacheStatus",
+			want:     "https://api.ngenix.net/reports/v1/timeline/configs?configId=123&start=2024-05-01T09:00:00&end=2024-05-01T09:59:59&metrics=realtimeRequests,realtimeTraffic&interval=60&groupBy=cacheStatus",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildReportURL("123", start, start.Add(time.Hour-time.Second), tt.metrics, tt.interval, tt.groupBy)
+			if err != nil {
+				t.Fatalf("buildReportURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("buildReportURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessReport(t *testing.T) {
+	resetMetrics(t, trafficGauge)
+
+	var report Report
+	err := json.Unmarshal([]byte(`{
+		"data": [
+			{"values": [
+				{"groupedBy": {"httpStatus": 200, "modelName": "cdn"}, "metrics": {"realtimeTraffic": 10}},
+				{"groupedBy": {"httpStatus": 200, "modelName": "vod"}, "metrics": {"realtimeTraffic": 4}},
+				{"groupedBy": {"httpStatus": 502, "modelName": "cdn"}, "metrics": {"realtimeTraffic": 1, "unknownMetric": 9}}
+			]},
+			{"values": [
+				{"groupedBy": {"httpStatus": 200, "modelName": "cdn"}, "metrics": {"realtimeTraffic": 5}}
+			]}
+		]
+	}`), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &timelineCollector{}
+	c.processReport("7", &report)
+
+	assertSeries(t, trafficGauge, map[string]float64{
+		"7,7,200,cdn": 15,
+		"7,7,200,vod": 4,
+		"7,7,502,cdn": 1,
+	})
+}
+
+func TestTimelineRange(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name               string
+		window, offset     time.Duration
+		wantStart, wantEnd string
+	}{
+		{"last hour", time.Hour, 0, "2024-05-01T09:00:00", "2024-05-01T10:00:00"},
+		{"with end offset", 15 * time.Minute, 2 * time.Minute, "2024-05-01T09:43:00", "2024-05-01T09:58:00"},
+		{"across midnight", 12 * time.Hour, 0, "2024-04-30T22:00:00", "2024-05-01T10:00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, timelineWindow, tt.window)
+			setFlag(t, timelineEndOffset, tt.offset)
+
+			start, end := timelineRange(now)
+			u, err := buildReportURL("1", start, end, []string{"realtimeTraffic"}, 30, "httpStatus")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := "start=" + tt.wantStart + "&end=" + tt.wantEnd + "&"
+			if !strings.Contains(u, want) {
+				t.Errorf("URL %s does not contain %s", u, want)
+			}
+		})
+	}
+}
+
+func TestProcessReportTwice(t *testing.T) {
+	resetMetrics(t, trafficGauge)
+
+	report := loadReport(t, "timeline.json")
+	c := &timelineCollector{}
+	c.processReport("1", report)
+	c.processReport("1", report)
+
+	assertSeries(t, trafficGauge, map[string]float64{
+		"1,1,200,cdn": 150,
+		"1,1,404,cdn": 7,
+	})
+}
+
+func TestTimelineAPIInterval(t *testing.T) {
+	setFlag(t, timelineAPIInterval, 300)
+	api := newFakeAPI(t, map[string]fixture{"/timeline/configs?groupBy=httpStatus": {file: "timeline.json"}})
+
+	var report Report
+	if err := fetchData(context.Background(), "1", &report); err != nil {
+		t.Fatalf("fetchData() error = %v", err)
+	}
+
+	if got := api.requests[0].Query().Get("interval"); got != "300" {
+		t.Errorf("interval = %q, want 300", got)
+	}
+
+	for interval, want := range map[int]bool{30: true, 300: true, 86400: true, 0: false, 45: false} {
+		if got := validTimelineAPIInterval(interval); got != want {
+			t.Errorf("validTimelineAPIInterval(%d) = %t, want %t", interval, got, want)
+		}
+	}
+}
+
+func TestTimelineEmptyResponses(t *testing.T) {
+	for _, summaryOnly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("summary-only=%t", summaryOnly), func(t *testing.T) {
+			resetMetrics(t, trafficGauge, trafficMax, trafficMin, trafficAvg)
+			timelineEmptyResponses.Reset()
+			t.Cleanup(timelineEmptyResponses.Reset)
+			setFlag(t, timelineSummaryOnly, summaryOnly)
+			newFakeAPI(t, map[string]fixture{"/timeline/configs?groupBy=httpStatus": {
+				body: `{"data": [], "summary": [{"groupedBy": {"httpStatus": 200}, "metrics": {"realtimeTraffic": {"max": 1, "min": 1, "avg": 1}}}]}`,
+			}})
+
+			c := &timelineCollector{configIDs: []string{"1"}}
+			if err := c.Collect(context.Background()); err != nil {
+				t.Fatalf("Collect() error = %v", err)
+			}
+
+			assertSeries(t, timelineEmptyResponses, map[string]float64{"1,1": 1})
+			assertSeries(t, trafficMax, map[string]float64{"1,1,200": 1})
+		})
+	}
+}
+
+func TestTimelineSummaryOnly(t *testing.T) {
+	resetMetrics(t, trafficGauge, trafficMax, trafficMin, trafficAvg, httpStatusDescription)
+	setFlag(t, timelineSummaryOnly, true)
+	newFakeAPI(t, map[string]fixture{"/timeline/configs?groupBy=httpStatus": {file: "timeline.json"}})
+
+	c := &timelineCollector{configIDs: []string{"1"}}
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	assertSeries(t, trafficGauge, map[string]float64{})
+	assertSeries(t, trafficMax, map[string]float64{"1,1,200": 100})
+	assertSeries(t, trafficMin, map[string]float64{"1,1,200": 50})
+	assertSeries(t, trafficAvg, map[string]float64{"1,1,200": 75})
+	assertSeries(t, httpStatusDescription, map[string]float64{"200,OK": 1, "404,Not Found": 1})
+}
+
+func TestTimelineMetricName(t *testing.T) {
+	tests := map[string]string{
+		"realtimeBandwidth": "bandwidth",
+		"realtimeTraffic":   "traffic",
+		"cacheHitRatio":     "cache_hit_ratio",
+		"cacheHitRatioURL":  "cache_hit_ratio_url",
+		"realtimeHTTPCodes": "http_codes",
+		"URLCount":          "url_count",
+		"realtime":          "realtime",
+		"realtimes":         "realtimes",
+	}
+
+	for metric, want := range tests {
+		if got := timelineMetricName(metric); got != want {
+			t.Errorf("timelineMetricName(%q) = %q, want %q", metric, got, want)
+		}
+	}
+}
+
+func TestDefaultTimelineMetrics(t *testing.T) {
+	if *timelineMetrics != defaultTimelineMetric {
+		t.Fatalf("-timeline.metrics defaults to %q, want %q", *timelineMetrics, defaultTimelineMetric)
+	}
+
+	if len(timelineGauges) != 1 || timelineGauges["realtimeTraffic"] != trafficGauge {
+		t.Errorf("default timeline gauges = %v, want only realtimeTraffic", timelineGauges)
+	}
+}
+
+func TestTimelineEvictsMissingSeries(t *testing.T) {
+	setFlag(t, smoothingAlpha, 0.5)
+	resetMetrics(t, trafficGauge)
+
+	c := &timelineCollector{}
+	process := func(body string) {
+		t.Helper()
+
+		var report Report
+		if err := json.Unmarshal([]byte(body), &report); err != nil {
+			t.Fatal(err)
+		}
+		c.processReport("1", &report)
+	}
+
+	process(`{"data": [{"values": [
+		{"groupedBy": {"httpStatus": 200, "modelName": "cdn"}, "metrics": {"realtimeTraffic": 100}},
+		{"groupedBy": {"httpStatus": 404, "modelName": "cdn"}, "metrics": {"realtimeTraffic": 8}}
+	]}]}`)
+	assertSeries(t, trafficGauge, map[string]float64{"1,1,200,cdn": 100, "1,1,404,cdn": 8})
+
+	process(`{"data": [{"values": [
+		{"groupedBy": {"httpStatus": 200, "modelName": "cdn"}, "metrics": {"realtimeTraffic": 50}}
+	]}]}`)
+	assertSeries(t, trafficGauge, map[string]float64{"1,1,200,cdn": 75})
+
+	// The evicted series starts from a fresh value instead of the smoothed one.
+	process(`{"data": [{"values": [
+		{"groupedBy": {"httpStatus": 404, "modelName": "cdn"}, "metrics": {"realtimeTraffic": 2}}
+	]}]}`)
+	assertSeries(t, trafficGauge, map[string]float64{"1,1,404,cdn": 2})
+}
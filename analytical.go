@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func fetchAnalyticalTopList(ctx context.Context, collector, endpoint, configID string, data *top100Response) error {
+	if data == nil {
+		return errors.New("data parameter is nil")
+	}
+
+	date := reportDate()
+	key := cacheKey{collector, configID, date.In(apiLocation).Format(queryDateLayout)}
+	if cached, ok := apiCache.get(key); ok {
+		*data = cached.(top100Response)
+		return nil
+	}
+
+	url, err := getAnalyticalURL(endpoint, configID, date, []string{"realtimeRequests"})
+	if err != nil {
+		return err
+	}
+	recordQueryDay(collector, date)
+
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
+
+	resp, err := doRequest(ctx, collector, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := decodeResponse(resp, collector, data); err != nil {
+		return err
+	}
+
+	apiCache.set(key, *data)
+	return nil
+}
+
+func getAnalyticalURL(endpoint, configID string, date time.Time, metrics []string) (string, error) {
+	if err := validateConfigID(configID); err != nil {
+		return "", err
+	}
+	if date.IsZero() {
+		return "", errors.New("missing report date")
+	}
+	if len(metrics) == 0 {
+		return "", errors.New("missing metrics")
+	}
+
+	params := url.Values{}
+	params.Set("configId", configID)
+	params.Set("date", date.In(apiLocation).Format(*apiDateLayout))
+	params.Set("metrics", strings.Join(metrics, ","))
+
+	return fmt.Sprintf("%s/reports/%s/analytical/%s?%s", apiBaseURL, *apiVersion, endpoint, params.Encode()), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetAnalyticalURL(t *testing.T) {
+	setFlag(t, &apiBaseURL, "https://api.ngenix.net")
+
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"referers", "https://api.ngenix.net/reports/v1/analytical/referers?configId=123&date=2024-05-01&metrics=realtimeRequests"},
+		{"useragents", "https://api.ngenix.net/reports/v1/analytical/useragents?configId=123&date=2024-05-01&metrics=realtimeRequests"},
+		{"countries", "https://api.ngenix.net/reports/v1/analytical/countries?configId=123&date=2024-05-01&metrics=realtimeRequests"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			got, err := getAnalyticalURL(tt.endpoint, "123", date, []string{"realtimeRequests"})
+			if err != nil {
+				t.Fatalf("getAnalyticalURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getAnalyticalURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Setenv("NGENIX_API_TOKEN", "test-token")
+
+	*scrapeMaxAttempts = 1
+
+	setupMetrics()
+	if err := setupTimelineMetrics(splitList(*timelineMetrics)); err != nil {
+		panic(err)
+	}
+	if err := setupAPIEndpoints(""); err != nil {
+		panic(err)
+	}
+
+	var err error
+	httpClient, err = newHTTPClient(clientConfig{
+		Timeout:               *scrapeTimeout,
+		MaxIdleConnsPerHost:   *httpMaxIdleConnsPerHost,
+		IdleConnTimeout:       *httpIdleConnTimeout,
+		ConnectTimeout:        *scrapeConnectTimeout,
+		ResponseHeaderTimeout: *scrapeResponseHeaderTimeout,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+type fixture struct {
+	status int
+	file   string
+	body   string
+}
+
+type fakeAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*url.URL
+}
+
+// newFakeAPI serves testdata fixtures keyed by the report path after the API
+// version, with the groupBy parameter appended for timeline requests, e.g.
+// "/analytical/top100" or "/timeline/configs?groupBy=httpStatus".
+func newFakeAPI(t *testing.T, routes map[string]fixture) *fakeAPI {
+	t.Helper()
+
+	api := &fakeAPI{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		api.requests = append(api.requests, r.URL)
+		api.mu.Unlock()
+
+		key := strings.TrimPrefix(r.URL.Path, "/reports/"+*apiVersion)
+		if groupBy := r.URL.Query().Get("groupBy"); groupBy != "" {
+			key += "?groupBy=" + groupBy
+		}
+
+		f, ok := routes[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if f.status == 0 {
+			f.status = http.StatusOK
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(f.status)
+		if f.file != "" {
+			data, err := os.ReadFile(filepath.Join("testdata", f.file))
+			if err != nil {
+				t.Errorf("reading fixture: %v", err)
+				return
+			}
+			w.Write(data)
+		}
+		io.WriteString(w, f.body)
+	}))
+	t.Cleanup(api.Close)
+
+	baseURL, endpoints := apiBaseURL, apiEndpoints
+	apiBaseURL, apiEndpoints = api.URL, []string{api.URL}
+	t.Cleanup(func() { apiBaseURL, apiEndpoints = baseURL, endpoints })
+
+	return api
+}
+
+func (a *fakeAPI) requestCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.requests)
+}
+
+// setFlag overrides a flag value for the duration of the test.
+func setFlag[T any](t *testing.T, p *T, value T) {
+	t.Helper()
+
+	old := *p
+	*p = value
+	t.Cleanup(func() { *p = old })
+}
+
+func resetMetrics(t *testing.T, vecs ...*prometheus.GaugeVec) {
+	t.Helper()
+
+	for _, vec := range vecs {
+		vec.Reset()
+	}
+	realtimeRequestsTotal.Reset()
+	t.Cleanup(func() {
+		for _, vec := range vecs {
+			vec.Reset()
+		}
+	})
+}
+
+// collectSeries returns the value of every series of c keyed by its label
+// values joined with ",", in label name order.
+func collectSeries(t *testing.T, c prometheus.Collector) map[string]float64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	series := make(map[string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("writing metric: %v", err)
+		}
+
+		values := make([]string, 0, len(pb.GetLabel()))
+		for _, label := range pb.GetLabel() {
+			values = append(values, label.GetValue())
+		}
+
+		var v float64
+		switch {
+		case pb.Gauge != nil:
+			v = pb.GetGauge().GetValue()
+		case pb.Counter != nil:
+			v = pb.GetCounter().GetValue()
+		default:
+			t.Fatalf("unsupported metric type: %v", m.Desc())
+		}
+		series[strings.Join(values, ",")] = v
+	}
+
+	return series
+}
+
+// toFloat64 returns the value of the only series of c, like testutil.ToFloat64.
+func toFloat64(t *testing.T, c prometheus.Collector) float64 {
+	t.Helper()
+
+	series := collectSeries(t, c)
+	if len(series) != 1 {
+		t.Fatalf("expected exactly one series, got %d: %v", len(series), series)
+	}
+	for _, v := range series {
+		return v
+	}
+
+	return 0
+}
+
+func assertSeries(t *testing.T, c prometheus.Collector, want map[string]float64) {
+	t.Helper()
+
+	got := collectSeries(t, c)
+	if len(got) != len(want) {
+		t.Errorf("got %d series %v, want %d series %v", len(got), got, len(want), want)
+		return
+	}
+	for labels, v := range want {
+		if g, ok := got[labels]; !ok || g != v {
+			t.Errorf("series {%s} = %v (present %t), want %v", labels, g, ok, v)
+		}
+	}
+}
+
+func TestTop100Collector(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture fixture
+		wantErr bool
+		want    map[string]float64
+		count   map[string]float64
+	}{
+		{
+			name:    "success",
+			fixture: fixture{file: "top100.json"},
+			want: map[string]float64{
+				"1,1,cdn,/index.html":    120,
+				"1,1,cdn,/static/app.js": 45,
+				"1,1,cdn,/favicon.ico":   0,
+			},
+			count: map[string]float64{"1,1": 3},
+		},
+		{
+			name:    "server error",
+			fixture: fixture{status: http.StatusInternalServerError},
+			wantErr: true,
+			want:    map[string]float64{},
+			count:   map[string]float64{},
+		},
+		{
+			name:    "malformed json",
+			fixture: fixture{file: "malformed.json"},
+			wantErr: true,
+			want:    map[string]float64{},
+			count:   map[string]float64{},
+		},
+		{
+			name:    "empty categories",
+			fixture: fixture{file: "top100_empty.json"},
+			want:    map[string]float64{},
+			count:   map[string]float64{"1,1": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMetrics(t, realtimeRequestsByPath, top100CategoriesCount)
+			newFakeAPI(t, map[string]fixture{"/analytical/top100": tt.fixture})
+
+			err := newTop100Collector([]string{"1"}).Collect(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Collect() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			assertSeries(t, realtimeRequestsByPath, tt.want)
+			assertSeries(t, top100CategoriesCount, tt.count)
+		})
+	}
+}
+
+func TestHTTPStatusCollector(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture fixture
+		wantErr bool
+		want    map[string]float64
+		classes map[string]float64
+	}{
+		{
+			name:    "success",
+			fixture: fixture{file: "httpstatus.json"},
+			want: map[string]float64{
+				"200,1,1,cdn": 900,
+				"404,1,1,cdn": 30,
+				"503,1,1,cdn": 5,
+			},
+			classes: map[string]float64{
+				"2xx,1,1,cdn": 900,
+				"4xx,1,1,cdn": 30,
+				"5xx,1,1,cdn": 5,
+			},
+		},
+		{
+			name:    "server error",
+			fixture: fixture{status: http.StatusInternalServerError},
+			wantErr: true,
+			want:    map[string]float64{},
+			classes: map[string]float64{},
+		},
+		{
+			name:    "malformed json",
+			fixture: fixture{file: "malformed.json"},
+			wantErr: true,
+			want:    map[string]float64{},
+			classes: map[string]float64{},
+		},
+		{
+			name:    "empty categories",
+			fixture: fixture{file: "top100_empty.json"},
+			want:    map[string]float64{},
+			classes: map[string]float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMetrics(t, realtimeRequestsByCode, realtimeRequestsByStatusClass, httpStatusCategoriesCount)
+			newFakeAPI(t, map[string]fixture{"/analytical/httpstatuses": tt.fixture})
+
+			err := newHTTPStatusCollector([]string{"1"}).Collect(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Collect() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			assertSeries(t, realtimeRequestsByCode, tt.want)
+			assertSeries(t, realtimeRequestsByStatusClass, tt.classes)
+		})
+	}
+}
+
+func TestTimelineCollector(t *testing.T) {
+	resetMetrics(t, trafficGauge, trafficMax, trafficMin, trafficAvg, httpStatusDescription)
+	newFakeAPI(t, map[string]fixture{"/timeline/configs?groupBy=httpStatus": {file: "timeline.json"}})
+
+	c := &timelineCollector{configIDs: []string{"1"}}
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	assertSeries(t, trafficGauge, map[string]float64{
+		"1,1,200,cdn": 150,
+		"1,1,404,cdn": 7,
+	})
+	assertSeries(t, httpStatusDescription, map[string]float64{
+		"200,OK":        1,
+		"404,Not Found": 1,
+	})
+}
+
+func TestTimelineCollectorServerError(t *testing.T) {
+	resetMetrics(t, trafficGauge, trafficMax, trafficMin, trafficAvg)
+	api := newFakeAPI(t, map[string]fixture{"/timeline/configs?groupBy=httpStatus": {file: "timeline.json"}})
+
+	c := &timelineCollector{configIDs: []string{"1"}}
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	api.Close()
+
+	newFakeAPI(t, map[string]fixture{"/timeline/configs?groupBy=httpStatus": {status: http.StatusInternalServerError}})
+	if err := c.Collect(context.Background()); err == nil {
+		t.Fatal("Collect() error = nil, want error")
+	}
+
+	assertSeries(t, trafficGauge, map[string]float64{})
+	assertSeries(t, trafficMax, map[string]float64{})
+}
+
+func TestCacheStatusCollector(t *testing.T) {
+	resetMetrics(t, realtimeRequestsByCacheStatus)
+	newFakeAPI(t, map[string]fixture{"/timeline/configs?groupBy=cacheStatus": {file: "cachestatus.json"}})
+
+	if err := newCacheStatusCollector([]string{"1"}).Collect(context.Background()); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	assertSeries(t, realtimeRequestsByCacheStatus, map[string]float64{
+		"HIT,1,1,cdn":  90,
+		"MISS,1,1,cdn": 20,
+	})
+}
+
+func TestRefererCollector(t *testing.T) {
+	resetMetrics(t, realtimeRequestsByReferer)
+	newFakeAPI(t, map[string]fixture{"/analytical/referers": {file: "top100.json"}})
+
+	if err := newRefererCollector([]string{"1"}).Collect(context.Background()); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	assertSeries(t, realtimeRequestsByReferer, map[string]float64{
+		"1,1,cdn,/index.html":    120,
+		"1,1,cdn,/static/app.js": 45,
+		"1,1,cdn,/favicon.ico":   0,
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const auditBackupTimeLayout = "20060102T150405.000"
+
+var auditLogger *slog.Logger
+
+// rotatingFile is an io.Writer that renames the file to a timestamped backup
+// once it exceeds maxSize and removes backups beyond maxBackups or older than maxAge.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	backup := f.path + "." + time.Now().UTC().Format(auditBackupTimeLayout)
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.prune()
+	return nil
+}
+
+func (f *rotatingFile) prune() {
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for i, backup := range backups {
+		ts, err := time.Parse(auditBackupTimeLayout, strings.TrimPrefix(backup, f.path+"."))
+		if err != nil {
+			continue
+		}
+		if (f.maxBackups > 0 && i >= f.maxBackups) || (f.maxAge > 0 && time.Since(ts) > f.maxAge) {
+			if err := os.Remove(backup); err != nil {
+				slog.Warn("Error removing audit log backup", "file", backup, "err", err)
+			}
+		}
+	}
+}
+
+func setupAuditLog(path string, maxSizeMB int, maxAge time.Duration, maxBackups int) error {
+	if path == "" {
+		return nil
+	}
+
+	f, err := newRotatingFile(path, int64(maxSizeMB)<<20, maxAge, maxBackups)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+
+	auditLogger = slog.New(slog.NewJSONHandler(f, nil))
+	return nil
+}
+
+func auditRequest(collector, requestURL string, attempt int, resp *http.Response, err error, duration time.Duration) {
+	if auditLogger == nil {
+		return
+	}
+
+	var configID string
+	if u, parseErr := url.Parse(requestURL); parseErr == nil {
+		configID = u.Query().Get("configId")
+	}
+
+	attrs := []any{"collector", collector, "configId", configID, "attempt", attempt, "duration", duration.Seconds()}
+	if err != nil {
+		attrs = append(attrs, "err", err.Error())
+	} else {
+		attrs = append(attrs, "status_code", resp.StatusCode)
+	}
+
+	auditLogger.Info("NGENIX API request", attrs...)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheKey struct {
+	endpoint string
+	configID string
+	date     string
+}
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[cacheKey]cacheEntry
+}
+
+var apiCache = newResponseCache(0)
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		entries: make(map[cacheKey]cacheEntry),
+	}
+}
+
+func (c *responseCache) get(key cacheKey) (any, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		cacheMisses.Inc()
+		return nil, false
+	}
+
+	cacheHits.Inc()
+	return entry.value, true
+}
+
+func (c *responseCache) set(key cacheKey, value any) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var realtimeRequestsByCacheStatus *prometheus.GaugeVec
+
+func setupCacheStatusMetrics() {
+	realtimeRequestsByCacheStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "requests_by_cache_status",
+			Help:      "Realtime requests in the current report window grouped by cache status",
+		},
+		[]string{"configId", "config_name", "model_name", "cache_status"},
+	)
+}
+
+type cacheStatusReport struct {
+	Query struct {
+		Metrics   []string `json:"metrics"`
+		GroupBy   []string `json:"groupBy"`
+		Start     string   `json:"start"`
+		End       string   `json:"end"`
+		Interval  int      `json:"interval"`
+		ModelName string   `json:"modelName"`
+	} `json:"query"`
+	Data []struct {
+		Timestamp time.Time `json:"timestamp"`
+		Values    []struct {
+			GroupedBy struct {
+				CacheStatus string `json:"cacheStatus"`
+			} `json:"groupedBy"`
+			Metrics struct {
+				RealtimeRequests float64 `json:"realtimeRequests"`
+			} `json:"metrics"`
+		} `json:"values"`
+	} `json:"data"`
+	ModelName string `json:"modelName"`
+}
+
+type cacheStatusCollector struct {
+	configIDs       []string
+	seenCacheStatus *labelTracker
+}
+
+func newCacheStatusCollector(configIDs []string) *cacheStatusCollector {
+	return &cacheStatusCollector{
+		configIDs:       configIDs,
+		seenCacheStatus: newLabelTracker(realtimeRequestsByCacheStatus),
+	}
+}
+
+func (c *cacheStatusCollector) Collect(ctx context.Context) error {
+	return forEachConfig(ctx, c.configIDs, func(configID string) error {
+		var report cacheStatusReport
+		if err := fetchDataCacheStatus(ctx, configID, &report); err != nil {
+			slog.Error("Error fetching data", "collector", collectorCacheStatus, "configId", configID, "err", err)
+			c.seenCacheStatus.reset(configID)
+			return fmt.Errorf("config %s: %w", configID, err)
+		}
+		status.recordSuccess(collectorCacheStatus, time.Now())
+		model := modelLabel(report.ModelName)
+
+		totals := make(map[string]float64)
+		for _, data := range report.Data {
+			for _, value := range data.Values {
+				if value.GroupedBy.CacheStatus == "" {
+					continue
+				}
+				totals[value.GroupedBy.CacheStatus] += value.Metrics.RealtimeRequests
+			}
+		}
+
+		current := make(map[string]struct{}, len(totals))
+		for cacheStatus, total := range totals {
+			setSmoothed(realtimeRequestsByCacheStatus, total, configID, configName(configID), model, cacheStatus)
+			current[cacheStatus] = struct{}{}
+		}
+		c.seenCacheStatus.evictMissing(configID, model, current)
+
+		return nil
+	})
+}
+
+func fetchDataCacheStatus(ctx context.Context, configID string, report *cacheStatusReport) error {
+	if report == nil {
+		return errors.New("report parameter is nil")
+	}
+
+	start, end := timelineRange(time.Now())
+
+	key := cacheKey{collectorCacheStatus, configID, end.Truncate(time.Duration(*timelineAPIInterval) * time.Second).Format(timelineTimeLayout)}
+	if cached, ok := apiCache.get(key); ok {
+		*report = cached.(cacheStatusReport)
+		return nil
+	}
+
+	url, err := buildReportURL(configID, start, end, []string{"realtimeRequests"}, *timelineAPIInterval, "cacheStatus")
+	if err != nil {
+		return err
+	}
+	recordQueryWindow(collectorCacheStatus, start, end)
+
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
+
+	resp, err := doRequest(ctx, collectorCacheStatus, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := decodeResponse(resp, collectorCacheStatus, report); err != nil {
+		return err
+	}
+
+	apiCache.set(key, *report)
+	return nil
+}
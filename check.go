@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func runCheck(ctx context.Context, configIDs []string) bool {
+	ok := true
+	report := func(collector, configID string, rows int, err error) {
+		if err != nil {
+			ok = false
+			var statusErr *statusError
+			if errors.As(err, &statusErr) {
+				fmt.Printf("%s config %s: FAILED (status %d): %v\n", collector, configID, statusErr.StatusCode, err)
+				return
+			}
+			fmt.Printf("%s config %s: FAILED: %v\n", collector, configID, err)
+			return
+		}
+		fmt.Printf("%s config %s: OK (status %d, %d rows)\n", collector, configID, http.StatusOK, rows)
+	}
+
+	for _, configID := range configIDs {
+		if *enableTimeline {
+			var timeline Report
+			err := fetchData(ctx, configID, &timeline)
+			report(collectorTimeline, configID, len(timeline.Data), err)
+		}
+
+		if *enableTop100 {
+			var top100 top100Response
+			err := fetchDataTOP100(ctx, configID, reportDate(), &top100)
+			report(collectorTop100, configID, len(top100.Categories), err)
+		}
+
+		if *enableHTTPStatus {
+			var httpStatus httpStatusResponse
+			err := fetchDataHTTPStatus(ctx, configID, reportDate(), &httpStatus)
+			report(collectorHTTPStatus, configID, len(httpStatus.Categories), err)
+		}
+
+		if *enableCacheStatus {
+			var cacheStatus cacheStatusReport
+			err := fetchDataCacheStatus(ctx, configID, &cacheStatus)
+			report(collectorCacheStatus, configID, len(cacheStatus.Data), err)
+		}
+
+		if *enableReferers {
+			var referers top100Response
+			err := fetchAnalyticalTopList(ctx, collectorReferers, "referers", configID, &referers)
+			report(collectorReferers, configID, len(referers.Categories), err)
+		}
+
+		if *enableUserAgents {
+			var userAgents top100Response
+			err := fetchAnalyticalTopList(ctx, collectorUserAgents, "useragents", configID, &userAgents)
+			report(collectorUserAgents, configID, len(userAgents.Categories), err)
+		}
+
+		if *enableCountries {
+			var countries top100Response
+			err := fetchAnalyticalTopList(ctx, collectorCountries, "countries", configID, &countries)
+			report(collectorCountries, configID, len(countries.Categories), err)
+		}
+	}
+
+	return ok
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	maxRetryAfter  = time.Minute
+
+	bodySnippetBytes = 512
+)
+
+var errResponseTooLarge = errors.New("response body too large")
+
+type statusError struct {
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	if e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden {
+		return fmt.Sprintf("authentication failed: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("unexpected status code: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+var httpClient *http.Client
+
+type clientConfig struct {
+	Timeout            time.Duration
+	ProxyURL           string
+	CAFile             string
+	InsecureSkipVerify bool
+
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	ConnectTimeout        time.Duration
+	ResponseHeaderTimeout time.Duration
+}
+
+func newHTTPClient(cfg clientConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	transport.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	transport.DialContext = (&net.Dialer{
+		Timeout:   cfg.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+
+	if cfg.ProxyURL != "" {
+		u, err := url.Parse(cfg.ProxyURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", cfg.ProxyURL)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification for the NGENIX API is disabled")
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+	}, nil
+}
+
+func setAuth(req *http.Request) error {
+	creds, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+
+	if creds.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+creds.Token)
+		return nil
+	}
+
+	if creds.Username == "" || creds.Password == "" {
+		return errors.New("missing credentials: set NGENIX_API_TOKEN or NGENIX_USERNAME and NGENIX_PASSWORD")
+	}
+
+	req.SetBasicAuth(creds.Username, creds.Password)
+	return nil
+}
+
+func doRequest(ctx context.Context, collector, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	if err := setAuth(req); err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent())
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	var resp *http.Response
+	var attempt int
+	var start time.Time
+	for attempt = 1; ; attempt++ {
+		start = time.Now()
+		resp, err = doWithFailover(req)
+		auditRequest(collector, url, attempt, resp, err, time.Since(start))
+		if err == nil {
+			apiResponseCodes.WithLabelValues(collector, strconv.Itoa(resp.StatusCode)).Inc()
+			if resp.StatusCode == http.StatusTooManyRequests {
+				rateLimited.Inc()
+			}
+			if resp.StatusCode != http.StatusOK {
+				slog.Debug("NGENIX API request", "collector", collector, "url", url, "attempt", attempt, "status_code", resp.StatusCode, "duration", time.Since(start))
+			}
+		} else {
+			slog.Debug("NGENIX API request", "collector", collector, "url", url, "attempt", attempt, "err", err, "duration", time.Since(start))
+		}
+
+		if attempt >= *scrapeMaxAttempts || !shouldRetry(ctx, resp, err) {
+			break
+		}
+
+		delay := backoff(attempt)
+		if err != nil {
+			slog.Warn("Retrying NGENIX API request", "collector", collector, "url", url, "attempt", attempt, "err", err)
+		} else {
+			slog.Warn("Retrying NGENIX API request", "collector", collector, "url", url, "attempt", attempt, "status_code", resp.StatusCode)
+		}
+
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp, time.Now()); ok {
+				delay = retryAfter
+			}
+			resp.Body.Close()
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error executing request: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		authFailures.WithLabelValues(collector).Inc()
+		slog.Error("NGENIX API authentication failed, check credentials", "collector", collector, "status_code", resp.StatusCode)
+		return nil, &statusError{StatusCode: resp.StatusCode}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &statusError{StatusCode: resp.StatusCode}
+	}
+
+	resp.Body = &loggingBody{ReadCloser: resp.Body, collector: collector, url: url, attempt: attempt, start: start}
+	return resp, nil
+}
+
+type loggingBody struct {
+	io.ReadCloser
+	collector string
+	url       string
+	attempt   int
+	start     time.Time
+	bytes     int64
+}
+
+func (b *loggingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	return n, err
+}
+
+func (b *loggingBody) Close() error {
+	slog.Debug("NGENIX API request", "collector", b.collector, "url", b.url, "attempt", b.attempt, "status_code", http.StatusOK, "bytes", b.bytes, "duration", time.Since(b.start))
+	return b.ReadCloser.Close()
+}
+
+func responseReader(resp *http.Response) (io.ReadCloser, error) {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil, errors.New("missing content type, expected application/json")
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return nil, fmt.Errorf("unexpected content type %q, expected application/json", contentType)
+	}
+
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip response: %w", err)
+		}
+		return gz, nil
+	}
+
+	return io.NopCloser(resp.Body), nil
+}
+
+type limitedBodyReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedBodyReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		if n, err := l.r.Read(make([]byte, 1)); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: limit is %d bytes", errResponseTooLarge, *scrapeMaxBodyBytes)
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+func decodeResponse(resp *http.Response, collector string, v any) error {
+	reader, err := responseReader(resp)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	body, err := io.ReadAll(io.LimitReader(reader, *scrapeMaxBodyBytes+1))
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if int64(len(body)) > *scrapeMaxBodyBytes {
+		return fmt.Errorf("%w: limit is %d bytes", errResponseTooLarge, *scrapeMaxBodyBytes)
+	}
+
+	start := time.Now()
+	err = json.Unmarshal(body, v)
+	decodeDuration.WithLabelValues(collector).Observe(time.Since(start).Seconds())
+	if err != nil {
+		snippet := body[:min(len(body), bodySnippetBytes)]
+		slog.Debug("Malformed NGENIX API response", "url", resp.Request.URL.String(), "body", string(snippet))
+		return fmt.Errorf("error decoding response: %w (body: %q)", err, snippet)
+	}
+
+	return nil
+}
+
+// prefixBuffer keeps the first max bytes written to it and discards the rest.
+type prefixBuffer struct {
+	buf []byte
+	max int
+}
+
+func (b *prefixBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); room > 0 {
+		b.buf = append(b.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func decodeResponseStream(resp *http.Response, collector string, decode func(*json.Decoder) error) error {
+	reader, err := responseReader(resp)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	head := &prefixBuffer{max: bodySnippetBytes}
+	body := io.TeeReader(&limitedBodyReader{r: reader, remaining: *scrapeMaxBodyBytes}, head)
+
+	start := time.Now()
+	err = decode(json.NewDecoder(body))
+	decodeDuration.WithLabelValues(collector).Observe(time.Since(start).Seconds())
+	if err != nil {
+		if errors.Is(err, errResponseTooLarge) {
+			return err
+		}
+		slog.Debug("Malformed NGENIX API response", "url", resp.Request.URL.String(), "body", string(head.buf))
+		return fmt.Errorf("error decoding response: %w (body: %q)", err, head.buf)
+	}
+
+	return nil
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	return delay + time.Duration(rand.Int63n(int64(delay)))
+}
+
+func parseRetryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(now)
+	} else {
+		return 0, false
+	}
+
+	return min(max(delay, 0), maxRetryAfter), true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientProxy(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://api.ngenix.net/reports/v1/analytical/top100", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("environment", func(t *testing.T) {
+		client, err := newHTTPClient(clientConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// http.ProxyFromEnvironment reads the environment only once per
+		// process, so check that it is the configured proxy function.
+		proxy := client.Transport.(*http.Transport).Proxy
+		if reflect.ValueOf(proxy).Pointer() != reflect.ValueOf(http.ProxyFromEnvironment).Pointer() {
+			t.Error("transport does not use http.ProxyFromEnvironment")
+		}
+	})
+
+	t.Run("explicit URL", func(t *testing.T) {
+		t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+
+		client, err := newHTTPClient(clientConfig{ProxyURL: "http://proxy.example.com:8080"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := client.Transport.(*http.Transport).Proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u == nil || u.String() != "http://proxy.example.com:8080" {
+			t.Errorf("proxy = %v, want http://proxy.example.com:8080", u)
+		}
+	})
+
+	t.Run("invalid URL", func(t *testing.T) {
+		if _, err := newHTTPClient(clientConfig{ProxyURL: "proxy.example.com"}); err == nil {
+			t.Error("newHTTPClient() error = nil, want error for a proxy URL without scheme")
+		}
+	})
+}
+
+func TestFetchCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	setFlag(t, &apiBaseURL, server.URL)
+	setFlag(t, &apiEndpoints, []string{server.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		var report Report
+		done <- fetchData(ctx, "1", &report)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("fetchData() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fetchData() did not return after the context was cancelled")
+	}
+}
+
+func TestResponseContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		wantErr     bool
+	}{
+		{"application/json", false},
+		{"application/json; charset=utf-8", false},
+		{"application/problem+json", false},
+		{"text/html", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}, Body: io.NopCloser(strings.NewReader("<html></html>"))}
+		if tt.contentType != "" {
+			resp.Header.Set("Content-Type", tt.contentType)
+		}
+
+		_, err := responseReader(resp)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("responseReader() with Content-Type %q error = %v, wantErr %t", tt.contentType, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRateLimitFailover(t *testing.T) {
+	var servers []string
+	for range 2 {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(server.Close)
+		servers = append(servers, server.URL)
+	}
+	setFlag(t, &apiBaseURL, servers[0])
+	setFlag(t, &apiEndpoints, servers)
+	setFlag(t, &apiLimiter, newTokenBucket(20, 1))
+
+	throttled := toFloat64(t, throttledRequests)
+
+	req, err := http.NewRequest(http.MethodGet, servers[0]+"/reports/v1/analytical/top100", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := doWithFailover(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := toFloat64(t, throttledRequests) - throttled; got != 1 {
+		t.Errorf("throttled %v requests, want the failover request to wait for a token", got)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Collector interface {
+	Collect(ctx context.Context) error
+}
+
+type scheduledCollector struct {
+	name      string
+	interval  time.Duration
+	collector Collector
+}
+
+func runScheduler(ctx context.Context, collectors []scheduledCollector) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	running := make([]bool, len(collectors))
+	done := make(chan int)
+
+	next := make([]time.Time, len(collectors))
+	now := time.Now()
+	for i, c := range collectors {
+		next[i] = now.Add(startDelay(c.interval, *scrapeJitter))
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case i := <-done:
+			running[i] = false
+			continue
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		earliest := now.Add(time.Hour)
+		for i, c := range collectors {
+			if !next[i].After(now) {
+				next[i] = now.Add(c.interval)
+				if !running[i] {
+					running[i] = true
+					wg.Add(1)
+					go func(i int, c scheduledCollector) {
+						defer wg.Done()
+						runCollector(ctx, c)
+						select {
+						case done <- i:
+						case <-ctx.Done():
+						}
+					}(i, c)
+				}
+			}
+
+			if next[i].Before(earliest) {
+				earliest = next[i]
+			}
+		}
+
+		timer.Reset(earliest.Sub(now))
+	}
+}
+
+func forEachConfig(ctx context.Context, configIDs []string, fn func(configID string) error) error {
+	errs := make([]error, len(configIDs))
+	sem := make(chan struct{}, *scrapeConcurrency)
+
+	var wg sync.WaitGroup
+	for i, configID := range configIDs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = fn(configID)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func parseEnabledCollectors(value string) (map[string]bool, error) {
+	names := splitList(value)
+	if len(names) == 0 {
+		return nil, errors.New("no collectors enabled")
+	}
+
+	enabled := make(map[string]bool, len(collectorNames))
+	for _, name := range names {
+		if name == "all" {
+			for _, n := range collectorNames {
+				enabled[n] = true
+			}
+			continue
+		}
+
+		if !slices.Contains(collectorNames, name) {
+			return nil, fmt.Errorf("unknown collector %q, expected one of %v or all", name, collectorNames)
+		}
+		enabled[name] = true
+	}
+
+	return enabled, nil
+}
+
+func startDelay(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 || jitter > interval {
+		jitter = interval
+	}
+
+	return time.Duration(rand.Int63n(int64(jitter)))
+}
+
+func runCollector(ctx context.Context, c scheduledCollector) {
+	start := time.Now()
+	err := collectSafely(ctx, c)
+	observeScrape(c.name, start, err)
+	setCollectorUp(c.name, err == nil)
+}
+
+func collectSafely(ctx context.Context, c scheduledCollector) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Collector panicked", "collector", c.name, "panic", r, "stack", string(debug.Stack()))
+			collectorPanics.WithLabelValues(c.name).Inc()
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return c.collector.Collect(ctx)
+}
+
+type onDemandCollector struct {
+	ctx       context.Context
+	collector scheduledCollector
+	metrics   []prometheus.Collector
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	lastRun time.Time
+	running chan struct{}
+}
+
+// newOnDemandCollector runs c when /metrics is scraped. Runs are cancelled
+// with ctx, which is cancelled on shutdown.
+func newOnDemandCollector(ctx context.Context, c scheduledCollector, ttl time.Duration, metrics ...prometheus.Collector) *onDemandCollector {
+	return &onDemandCollector{
+		ctx:       ctx,
+		collector: c,
+		metrics:   metrics,
+		ttl:       ttl,
+	}
+}
+
+func (c *onDemandCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		m.Describe(ch)
+	}
+}
+
+func (c *onDemandCollector) Collect(ch chan<- prometheus.Metric) {
+	c.refresh()
+
+	for _, m := range c.metrics {
+		m.Collect(ch)
+	}
+}
+
+// refresh runs the collector when the last run is older than the TTL.
+// Concurrent scrapes share a single run, and each stops waiting for it after
+// -scrape.timeout and serves the current values.
+func (c *onDemandCollector) refresh() {
+	c.mu.Lock()
+	if time.Since(c.lastRun) < c.ttl {
+		c.mu.Unlock()
+		return
+	}
+	done := c.running
+	if done == nil {
+		done = make(chan struct{})
+		c.running = done
+		go c.run(done)
+	}
+	c.mu.Unlock()
+
+	timer := time.NewTimer(*scrapeTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		slog.Warn("On-demand collection timed out, serving previous values", "collector", c.collector.name)
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *onDemandCollector) run(done chan struct{}) {
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(c.ctx, *scrapeTimeout)
+	defer cancel()
+	runCollector(ctx, c.collector)
+
+	c.mu.Lock()
+	c.lastRun = time.Now()
+	c.running = nil
+	c.mu.Unlock()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type panickingCollector struct {
+	calls atomic.Int32
+}
+
+func (c *panickingCollector) Collect(ctx context.Context) error {
+	c.calls.Add(1)
+	panic("boom")
+}
+
+func TestCollectorPanicRestarts(t *testing.T) {
+	collectorPanics.Reset()
+	t.Cleanup(collectorPanics.Reset)
+
+	c := &panickingCollector{}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	runScheduler(ctx, []scheduledCollector{{name: "panicky", interval: 10 * time.Millisecond, collector: c}})
+
+	calls := c.calls.Load()
+	if calls < 2 {
+		t.Fatalf("collector ran %d times, want it to keep running after panics", calls)
+	}
+	if got := toFloat64(t, collectorPanics.WithLabelValues("panicky")); got != float64(calls) {
+		t.Errorf("collector_panics_total = %v, want %d", got, calls)
+	}
+}
+
+func TestMultipleConfigs(t *testing.T) {
+	resetMetrics(t, realtimeRequestsByCode, realtimeRequestsByStatusClass, httpStatusCategoriesCount)
+	api := newFakeAPI(t, map[string]fixture{"/analytical/httpstatuses": {file: "httpstatus.json"}})
+
+	if err := newHTTPStatusCollector([]string{"1", "2", "3"}).Collect(context.Background()); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	if got := api.requestCount(); got != 3 {
+		t.Errorf("got %d requests, want one per config", got)
+	}
+	configs := make(map[string]bool)
+	for _, u := range api.requests {
+		configs[u.Query().Get("configId")] = true
+	}
+	if len(configs) != 3 {
+		t.Errorf("requested configs %v, want 1, 2 and 3", configs)
+	}
+
+	want := make(map[string]float64)
+	for _, id := range []string{"1", "2", "3"} {
+		want["200,"+id+","+id+",cdn"] = 900
+		want["404,"+id+","+id+",cdn"] = 30
+		want["503,"+id+","+id+",cdn"] = 5
+	}
+	assertSeries(t, realtimeRequestsByCode, want)
+}
+
+func TestForEachConfigConcurrency(t *testing.T) {
+	setFlag(t, scrapeConcurrency, 2)
+
+	var inFlight, peak atomic.Int32
+	err := forEachConfig(context.Background(), []string{"1", "2", "3", "4", "5", "6"}, func(configID string) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("forEachConfig() error = %v", err)
+	}
+
+	if got := peak.Load(); got != 2 {
+		t.Errorf("peak concurrency = %d, want 2", got)
+	}
+}
+
+type blockingCollector struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (c *blockingCollector) Collect(ctx context.Context) error {
+	c.calls.Add(1)
+	select {
+	case <-c.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestOnDemandCollectorSingleFlight(t *testing.T) {
+	setFlag(t, scrapeTimeout, 50*time.Millisecond)
+
+	bc := &blockingCollector{release: make(chan struct{})}
+	c := newOnDemandCollector(context.Background(), scheduledCollector{name: "ondemand", collector: bc}, time.Minute)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.refresh()
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("scrapes waited %s for a blocked run, want about -scrape.timeout", elapsed)
+	}
+	if got := bc.calls.Load(); got != 1 {
+		t.Errorf("collector ran %d times for concurrent scrapes, want 1", got)
+	}
+
+	c.mu.Lock()
+	done := c.running
+	c.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+
+	c.refresh()
+	if got := bc.calls.Load(); got != 1 {
+		t.Errorf("collector ran %d times within the TTL, want 1", got)
+	}
+}
+
+func TestOnDemandCollectorShutdown(t *testing.T) {
+	setFlag(t, scrapeTimeout, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bc := &blockingCollector{release: make(chan struct{})}
+	c := newOnDemandCollector(ctx, scheduledCollector{name: "ondemand", collector: bc}, time.Minute)
+
+	refreshed := make(chan struct{})
+	go func() {
+		c.refresh()
+		close(refreshed)
+	}()
+
+	for bc.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.mu.Lock()
+	done := c.running
+	c.mu.Unlock()
+	cancel()
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("scrape still waiting after shutdown")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("collection still running after shutdown")
+	}
+}
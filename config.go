@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var knownAPIVersions = []string{"v1"}
+
+var apiVersionPattern = regexp.MustCompile(`^v[1-9][0-9]*$`)
+
+var (
+	apiBaseURL  = strings.TrimSuffix(getEnv("NGENIX_API_BASE_URL", "https://api.ngenix.net"), "/")
+	apiLocation = time.UTC
+)
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// flagEnvName maps a flag such as collector.top100.interval to
+// NGENIX_TOP100_INTERVAL. The collector. prefix is dropped for brevity.
+func flagEnvName(name string) string {
+	name = strings.TrimPrefix(name, "collector.")
+	return "NGENIX_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// applyFlagEnv sets numeric and duration flags that were not given on the
+// command line from their environment variables, and marks them as explicit
+// so the config file does not override them.
+func applyFlagEnv(fs *flag.FlagSet, explicit map[string]bool) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+
+		getter, ok := f.Value.(flag.Getter)
+		if !ok {
+			return
+		}
+		switch getter.Get().(type) {
+		case int, int64, uint, uint64, float64, time.Duration:
+		default:
+			return
+		}
+
+		key := flagEnvName(f.Name)
+		value, ok := os.LookupEnv(key)
+		if !ok || value == "" {
+			return
+		}
+		if f.Value.Set(value) != nil {
+			err = fmt.Errorf("%s: invalid value %q for -%s", key, value, f.Name)
+			return
+		}
+		explicit[f.Name] = true
+	})
+
+	return err
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+var configNames = make(map[string]string)
+
+func configName(configID string) string {
+	if name, ok := configNames[configID]; ok {
+		return name
+	}
+
+	return configID
+}
+
+func getConfigIDs() ([]string, error) {
+	value := os.Getenv("NGENIX_CONFIG_IDS")
+	if value == "" {
+		value = os.Getenv("NGENIX_CONFIG_ID")
+	}
+
+	configIDs := splitList(value)
+	if len(configIDs) == 0 {
+		for _, c := range configFile.Configs {
+			configIDs = append(configIDs, c.ID)
+			if c.Label != "" {
+				configNames[c.ID] = c.Label
+			}
+		}
+	}
+	if len(configIDs) == 0 {
+		return nil, errors.New("missing config id")
+	}
+
+	if names := splitList(os.Getenv("NGENIX_CONFIG_NAMES")); len(names) > 0 {
+		if len(names) != len(configIDs) {
+			return nil, fmt.Errorf("NGENIX_CONFIG_NAMES has %d names for %d config ids", len(names), len(configIDs))
+		}
+		for i, name := range names {
+			configNames[configIDs[i]] = name
+		}
+	}
+
+	for _, configID := range configIDs {
+		if err := validateConfigID(configID); err != nil {
+			return nil, err
+		}
+	}
+
+	return configIDs, nil
+}
+
+func validateConfigID(configID string) error {
+	if configID == "" {
+		return errors.New("missing config id")
+	}
+
+	if _, err := strconv.Atoi(configID); err != nil {
+		return fmt.Errorf("invalid config id %q: must be numeric", configID)
+	}
+
+	return nil
+}
+
+type credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+func loadCredentials() (credentials, error) {
+	var creds credentials
+	var err error
+
+	if creds.Username, err = getSecret("NGENIX_USERNAME"); err != nil {
+		return creds, err
+	}
+	if creds.Password, err = getSecret("NGENIX_PASSWORD"); err != nil {
+		return creds, err
+	}
+	if creds.Token, err = getSecret("NGENIX_API_TOKEN"); err != nil {
+		return creds, err
+	}
+
+	if creds == (credentials{}) {
+		c := configFile.Credentials
+		creds = credentials{Username: c.Username, Password: c.Password, Token: c.Token}
+		if c.TokenFile != "" {
+			data, err := os.ReadFile(c.TokenFile)
+			if err != nil {
+				return creds, fmt.Errorf("error reading credentials token_file: %w", err)
+			}
+			creds.Token = strings.TrimRight(string(data), " \t\r\n")
+		}
+	}
+
+	return creds, nil
+}
+
+func envCredentials() bool {
+	for _, key := range []string{"NGENIX_USERNAME", "NGENIX_PASSWORD", "NGENIX_API_TOKEN"} {
+		if os.Getenv(key) != "" || os.Getenv(key+"_FILE") != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getSecret(key string) (string, error) {
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return os.Getenv(key), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s_FILE: %w", key, err)
+	}
+
+	return strings.TrimRight(string(data), " \t\r\n"), nil
+}
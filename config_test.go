@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlagEnvName(t *testing.T) {
+	tests := map[string]string{
+		"scrape.interval":              "NGENIX_SCRAPE_INTERVAL",
+		"collector.top100.interval":    "NGENIX_TOP100_INTERVAL",
+		"scrape.max-body-bytes":        "NGENIX_SCRAPE_MAX_BODY_BYTES",
+		"collector.smoothing-alpha":    "NGENIX_SMOOTHING_ALPHA",
+		"http.max-idle-conns-per-host": "NGENIX_HTTP_MAX_IDLE_CONNS_PER_HOST",
+	}
+
+	for name, want := range tests {
+		if got := flagEnvName(name); got != want {
+			t.Errorf("flagEnvName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestOptionPrecedence(t *testing.T) {
+	cfg := fileConfig{}
+	cfg.Configs = []fileConfigID{{ID: "1"}}
+	cfg.ScrapeIntervals.Timeline = duration(10 * time.Second)
+	cfg.ScrapeIntervals.Top100 = duration(20 * time.Second)
+
+	tests := []struct {
+		name         string
+		args         []string
+		env          map[string]string
+		wantTimeline time.Duration
+		wantTop100   time.Duration
+	}{
+		{
+			name:         "config file over defaults",
+			wantTimeline: 10 * time.Second,
+			wantTop100:   20 * time.Second,
+		},
+		{
+			name:         "environment over config file",
+			env:          map[string]string{"NGENIX_SCRAPE_INTERVAL": "1m", "NGENIX_TOP100_INTERVAL": "2m"},
+			wantTimeline: time.Minute,
+			wantTop100:   2 * time.Minute,
+		},
+		{
+			name:         "flag over environment",
+			args:         []string{"-scrape.interval=5s"},
+			env:          map[string]string{"NGENIX_SCRAPE_INTERVAL": "1m", "NGENIX_TOP100_INTERVAL": "2m"},
+			wantTimeline: 5 * time.Second,
+			wantTop100:   2 * time.Minute,
+		},
+		{
+			name:         "empty environment is ignored",
+			env:          map[string]string{"NGENIX_SCRAPE_INTERVAL": ""},
+			wantTimeline: 10 * time.Second,
+			wantTop100:   20 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, scrapeInterval, 30*time.Second)
+			setFlag(t, top100Interval, 5*time.Second)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.DurationVar(scrapeInterval, "scrape.interval", 30*time.Second, "")
+			fs.DurationVar(top100Interval, "collector.top100.interval", 5*time.Second, "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			explicit := make(map[string]bool)
+			fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+			if err := applyFlagEnv(fs, explicit); err != nil {
+				t.Fatalf("applyFlagEnv() error = %v", err)
+			}
+			if err := applyConfigFile(cfg, explicit); err != nil {
+				t.Fatalf("applyConfigFile() error = %v", err)
+			}
+
+			if *scrapeInterval != tt.wantTimeline {
+				t.Errorf("scrape.interval = %s, want %s", *scrapeInterval, tt.wantTimeline)
+			}
+			if *top100Interval != tt.wantTop100 {
+				t.Errorf("collector.top100.interval = %s, want %s", *top100Interval, tt.wantTop100)
+			}
+		})
+	}
+}
+
+func TestApplyFlagEnvInvalid(t *testing.T) {
+	t.Setenv("NGENIX_SCRAPE_INTERVAL", "soon")
+	t.Setenv("NGENIX_LOG_FORMAT", "json")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Duration("scrape.interval", time.Second, "")
+	format := fs.String("log.format", "text", "")
+
+	if err := applyFlagEnv(fs, map[string]bool{}); err == nil {
+		t.Error("applyFlagEnv() error = nil, want error for invalid duration")
+	}
+	if *format != "text" {
+		t.Errorf("string flag log.format = %q, want it left unchanged", *format)
+	}
+}
+
+func TestAPIBaseURL(t *testing.T) {
+	api := newFakeAPI(t, map[string]fixture{"/analytical/top100": {file: "top100.json"}})
+
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	timeline, err := buildReportURL("1", date, date.Add(time.Hour), []string{"realtimeTraffic"}, 30, "httpStatus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpStatus, err := getHTTPStatusURL("1", date, []string{"realtimeRequests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	top100, err := getTop100URL("1", date, []string{"realtimeRequests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for got, want := range map[string]string{
+		timeline:   api.URL + "/reports/v1/timeline/configs?configId=1&start=2024-05-01T00:00:00&end=2024-05-01T01:00:00&metrics=realtimeTraffic&interval=30&groupBy=httpStatus",
+		httpStatus: api.URL + "/reports/v1/analytical/httpstatuses?configId=1&end=2024-05-01T23%3A59%3A59&metrics=realtimeRequests&start=2024-05-01T00%3A00%3A00",
+		top100:     api.URL + "/reports/v1/analytical/top100?configId=1&date=2024-05-01&metrics=realtimeRequests",
+	} {
+		if got != want {
+			t.Errorf("got URL %s, want %s", got, want)
+		}
+	}
+
+	var response top100Response
+	if err := fetchDataTOP100(context.Background(), "1", date, &response); err != nil {
+		t.Fatalf("fetchDataTOP100() error = %v", err)
+	}
+	if n := api.requestCount(); n != 1 {
+		t.Errorf("fake API received %d requests, want 1", n)
+	}
+	if len(response.Categories) != 3 {
+		t.Errorf("got %d categories, want 3", len(response.Categories))
+	}
+}
+
+func TestValidateConfigID(t *testing.T) {
+	tests := []struct {
+		configID string
+		wantErr  bool
+	}{
+		{"12345", false},
+		{"0", false},
+		{"", true},
+		{"123 ", true},
+		{" 123", true},
+		{"12a", true},
+		{"1.5", true},
+	}
+
+	for _, tt := range tests {
+		if err := validateConfigID(tt.configID); (err != nil) != tt.wantErr {
+			t.Errorf("validateConfigID(%q) error = %v, wantErr %t", tt.configID, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	for version, want := range map[string]bool{"v1": true, "v2": true, "v10": true, "v0": false, "1": false, "V2": false, "": false} {
+		if got := apiVersionPattern.MatchString(version); got != want {
+			t.Errorf("apiVersionPattern.MatchString(%q) = %t, want %t", version, got, want)
+		}
+	}
+
+	setFlag(t, &apiBaseURL, "https://api.ngenix.net")
+	setFlag(t, apiVersion, "v2")
+
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	metrics := []string{"realtimeRequests"}
+	builders := map[string]func() (string, error){
+		"timeline": func() (string, error) {
+			return buildReportURL("1", date, date.Add(time.Hour), metrics, 30, "httpStatus")
+		},
+		"httpstatus": func() (string, error) { return getHTTPStatusURL("1", date, metrics) },
+		"top100":     func() (string, error) { return getTop100URL("1", date, metrics) },
+		"analytical": func() (string, error) { return getAnalyticalURL("referers", "1", date, metrics) },
+	}
+
+	for name, build := range builders {
+		u, err := build()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !strings.HasPrefix(u, "https://api.ngenix.net/reports/v2/") {
+			t.Errorf("%s URL %s does not use API version v2", name, u)
+		}
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	setFlag(t, top100Interval, 7*time.Second)
+	setFlag(t, scrapeInterval, 11*time.Second)
+
+	rec := httptest.NewRecorder()
+	configHandler([]string{"1"})(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+
+	var cfg effectiveConfig
+	if err := json.Unmarshal(rec.Body.Bytes(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range collectorNames {
+		if _, ok := cfg.Intervals[name]; !ok {
+			t.Errorf("missing interval for collector %q", name)
+		}
+	}
+	if got := cfg.Intervals[collectorCountries]; got != "7s" {
+		t.Errorf("countries interval = %q, want 7s", got)
+	}
+	if got := cfg.Intervals[collectorCacheStatus]; got != "11s" {
+		t.Errorf("cachestatus interval = %q, want 11s", got)
+	}
+
+	t.Setenv("NGENIX_API_TOKEN_FILE", "/nonexistent/secret/token")
+	rec = httptest.NewRecorder()
+	configHandler([]string{"1"})(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "/nonexistent") {
+		t.Errorf("error response leaks details: %q", rec.Body.String())
+	}
+}
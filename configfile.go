@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileConfig struct {
+	ListenAddress   string          `json:"listen_address"`
+	Credentials     fileCredentials `json:"credentials"`
+	Configs         []fileConfigID  `json:"configs"`
+	ScrapeIntervals struct {
+		Timeline   duration `json:"timeline"`
+		Top100     duration `json:"top100"`
+		HTTPStatus duration `json:"httpstatus"`
+	} `json:"scrape_intervals"`
+}
+
+type fileCredentials struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Token     string `json:"token"`
+	TokenFile string `json:"token_file"`
+}
+
+type fileConfigID struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+var configFile fileConfig
+
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return fmt.Errorf("duration must be positive, got %s", s)
+	}
+
+	*d = duration(v)
+	return nil
+}
+
+func loadConfigFile(path string) (fileConfig, error) {
+	var cfg fileConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if data, err = yamlToJSON(data); err != nil {
+			return cfg, fmt.Errorf("error parsing %s as YAML: %w", path, err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return cfg, fmt.Errorf("error parsing %s as JSON at byte %d: %w", path, syntaxErr.Offset, err)
+		}
+		return cfg, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	for i, c := range cfg.Configs {
+		if err := validateConfigID(c.ID); err != nil {
+			return cfg, fmt.Errorf("configs[%d]: %w", i, err)
+		}
+	}
+
+	if cfg.Credentials.Token != "" && cfg.Credentials.TokenFile != "" {
+		return cfg, errors.New("credentials: token and token_file are mutually exclusive")
+	}
+	if cfg.Credentials.Token == "" && (cfg.Credentials.Username == "") != (cfg.Credentials.Password == "") {
+		return cfg, errors.New("credentials: username and password must be set together")
+	}
+
+	return cfg, nil
+}
+
+// applyConfigFile sets options from the config file unless they were given
+// explicitly on the command line or in the environment. Credentials and
+// config IDs must come from either the file or the environment.
+func applyConfigFile(cfg fileConfig, explicit map[string]bool) error {
+	if cfg.Credentials == (fileCredentials{}) && !envCredentials() {
+		return errors.New("credentials: token, token_file or username and password are required")
+	}
+	if len(cfg.Configs) == 0 && os.Getenv("NGENIX_CONFIG_IDS") == "" && os.Getenv("NGENIX_CONFIG_ID") == "" {
+		return errors.New("configs: at least one config id is required")
+	}
+
+	if cfg.ListenAddress != "" && !explicit["web.listen-address"] {
+		*listenAddress = cfg.ListenAddress
+	}
+	if cfg.ScrapeIntervals.Timeline > 0 && !explicit["scrape.interval"] {
+		*scrapeInterval = time.Duration(cfg.ScrapeIntervals.Timeline)
+	}
+	if cfg.ScrapeIntervals.Top100 > 0 && !explicit["collector.top100.interval"] {
+		*top100Interval = time.Duration(cfg.ScrapeIntervals.Top100)
+	}
+	if cfg.ScrapeIntervals.HTTPStatus > 0 && !explicit["collector.httpstatus.interval"] {
+		*httpStatusInterval = time.Duration(cfg.ScrapeIntervals.HTTPStatus)
+	}
+
+	return nil
+}
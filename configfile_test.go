@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfigFileYAML(t *testing.T) {
+	yamlPath := writeConfigFile(t, "config.yaml", `---
+# NGENIX exporter
+listen_address: ":9200"
+credentials:
+  token_file: /run/secrets/ngenix # mounted secret
+configs:
+- id: 123
+  label: "main site"
+- id: '456'
+  label: Bob's CDN
+scrape_intervals:
+  timeline: 30s
+  top100: 5m
+`)
+	jsonPath := writeConfigFile(t, "config.json", `{
+		"listen_address": ":9200",
+		"credentials": {"token_file": "/run/secrets/ngenix"},
+		"configs": [{"id": "123", "label": "main site"}, {"id": "456", "label": "Bob's CDN"}],
+		"scrape_intervals": {"timeline": "30s", "top100": "5m"}
+	}`)
+
+	fromYAML, err := loadConfigFile(yamlPath)
+	if err != nil {
+		t.Fatalf("loadConfigFile(yaml) error = %v", err)
+	}
+	fromJSON, err := loadConfigFile(jsonPath)
+	if err != nil {
+		t.Fatalf("loadConfigFile(json) error = %v", err)
+	}
+
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("YAML config = %+v, want %+v", fromYAML, fromJSON)
+	}
+	if time.Duration(fromYAML.ScrapeIntervals.Top100) != 5*time.Minute {
+		t.Errorf("top100 interval = %s, want 5m", time.Duration(fromYAML.ScrapeIntervals.Top100))
+	}
+}
+
+func TestLoadConfigFileYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"unknown field", "listen_adress: :9200\n", "unknown field"},
+		{"bad indentation", "credentials:\n  token: a\n    token_file: b\n", "line 3"},
+		{"duplicate key", "listen_address: a\nlisten_address: b\n", "duplicate key"},
+		{"tab indentation", "credentials:\n\ttoken: a\n", "tabs"},
+		{"anchor", "listen_address: &addr :9200\n", "unsupported"},
+		{"invalid duration", "scrape_intervals:\n  timeline: soon\n", "invalid duration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfigFile(writeConfigFile(t, "config.yml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfigFile() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigFileRequired(t *testing.T) {
+	withConfigs := fileConfig{Configs: []fileConfigID{{ID: "1"}}}
+	withToken := fileConfig{Credentials: fileCredentials{TokenFile: "/run/secrets/ngenix"}}
+
+	tests := []struct {
+		name    string
+		cfg     fileConfig
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "credentials from environment", cfg: withConfigs},
+		{name: "no credentials", cfg: withConfigs, env: map[string]string{"NGENIX_API_TOKEN": ""}, wantErr: "credentials"},
+		{name: "no config ids", cfg: withToken, wantErr: "configs"},
+		{name: "config ids from environment", cfg: withToken, env: map[string]string{"NGENIX_CONFIG_IDS": "1,2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			err := applyConfigFile(tt.cfg, map[string]bool{})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("applyConfigFile() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("applyConfigFile() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
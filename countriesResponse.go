@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var realtimeRequestsByCountry *prometheus.GaugeVec
+
+func setupCountryMetrics() {
+	realtimeRequestsByCountry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "requests_by_country",
+			Help:      "Realtime requests grouped by country",
+		},
+		[]string{"configId", "config_name", "model_name", "country"},
+	)
+}
+
+type countryCollector struct {
+	configIDs     []string
+	seenCountries *labelTracker
+}
+
+func newCountryCollector(configIDs []string) *countryCollector {
+	return &countryCollector{
+		configIDs:     configIDs,
+		seenCountries: newLabelTracker(realtimeRequestsByCountry),
+	}
+}
+
+func (c *countryCollector) Collect(ctx context.Context) error {
+	return forEachConfig(ctx, c.configIDs, func(configID string) error {
+		var response top100Response
+		if err := fetchAnalyticalTopList(ctx, collectorCountries, "countries", configID, &response); err != nil {
+			slog.Error("Error fetching data", "collector", collectorCountries, "configId", configID, "err", err)
+			c.seenCountries.reset(configID)
+			return fmt.Errorf("config %s: %w", configID, err)
+		}
+		status.recordSuccess(collectorCountries, time.Now())
+
+		if response.Categories == nil {
+			slog.Warn("Incomplete data received", "collector", collectorCountries, "configId", configID)
+			return nil
+		}
+
+		model := modelLabel(response.ModelName)
+
+		countries := make(map[string]struct{}, len(response.Categories))
+		for _, category := range response.Categories {
+			if category.Name == "" {
+				continue
+			}
+
+			setSmoothed(realtimeRequestsByCountry, float64(category.Metrics.RealtimeRequests), configID, configName(configID), model, category.Name)
+			countries[category.Name] = struct{}{}
+		}
+		c.seenCountries.evictMissing(configID, model, countries)
+
+		return nil
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const redacted = "<redacted>"
+
+type effectiveConfig struct {
+	ListenAddress string            `json:"listen_address"`
+	APIBaseURL    string            `json:"api_base_url"`
+	APIEndpoints  []string          `json:"api_endpoints,omitempty"`
+	APIVersion    string            `json:"api_version"`
+	Configs       []effectiveTarget `json:"configs"`
+	Collectors    map[string]bool   `json:"collectors"`
+	Intervals     map[string]string `json:"intervals"`
+	Credentials   map[string]string `json:"credentials"`
+	OnDemand      bool              `json:"on_demand"`
+	Timezone      string            `json:"timezone"`
+}
+
+type effectiveTarget struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func configHandler(configIDs []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := effectiveConfig{
+			ListenAddress: *listenAddress,
+			APIBaseURL:    redactURL(apiBaseURL),
+			APIEndpoints:  redactURLs(apiEndpoints),
+			APIVersion:    *apiVersion,
+			Collectors: map[string]bool{
+				collectorTimeline:    *enableTimeline,
+				collectorTop100:      *enableTop100,
+				collectorHTTPStatus:  *enableHTTPStatus,
+				collectorCacheStatus: *enableCacheStatus,
+				collectorReferers:    *enableReferers,
+				collectorUserAgents:  *enableUserAgents,
+				collectorCountries:   *enableCountries,
+			},
+			Intervals: map[string]string{
+				"timeout":   scrapeTimeout.String(),
+				"cache_ttl": cacheTTL.String(),
+			},
+			Credentials: make(map[string]string),
+			OnDemand:    *scrapeOnDemand,
+			Timezone:    apiLocation.String(),
+		}
+
+		for _, name := range collectorNames {
+			cfg.Intervals[name] = collectorInterval(name).String()
+		}
+
+		for _, configID := range configIDs {
+			cfg.Configs = append(cfg.Configs, effectiveTarget{ID: configID, Name: configName(configID)})
+		}
+
+		creds, err := loadCredentials()
+		if err != nil {
+			slog.Error("Error loading credentials", "err", err)
+			http.Error(w, "error loading credentials", http.StatusInternalServerError)
+			return
+		}
+		for key, value := range map[string]string{"username": creds.Username, "password": creds.Password, "token": creds.Token} {
+			if value != "" {
+				cfg.Credentials[key] = redacted
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(cfg); err != nil {
+			slog.Error("Error encoding config", "err", err)
+		}
+	}
+}
+
+// collectorInterval returns the polling interval of a collector, matching the
+// intervals the scheduler is started with in main.
+func collectorInterval(name string) time.Duration {
+	switch name {
+	case collectorTop100, collectorReferers, collectorUserAgents, collectorCountries:
+		return *top100Interval
+	case collectorHTTPStatus:
+		return *httpStatusInterval
+	default:
+		return *scrapeInterval
+	}
+}
+
+func redactURLs(values []string) []string {
+	if len(values) <= 1 {
+		return nil
+	}
+
+	redactedURLs := make([]string, len(values))
+	for i, value := range values {
+		redactedURLs[i] = redactURL(value)
+	}
+
+	return redactedURLs
+}
+
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+
+	return u.Redacted()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// apiEndpoints holds the base URLs tried in order; apiBaseURL is always the first.
+var (
+	apiEndpoints   []string
+	activeEndpoint atomic.Int64
+)
+
+func setupAPIEndpoints(value string) error {
+	endpoints := splitList(value)
+	if len(endpoints) == 0 {
+		apiEndpoints = []string{apiBaseURL}
+		return nil
+	}
+
+	for i, endpoint := range endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid endpoint %q", endpoint)
+		}
+		endpoints[i] = strings.TrimSuffix(endpoint, "/")
+	}
+
+	apiEndpoints = endpoints
+	apiBaseURL = endpoints[0]
+	return nil
+}
+
+// sendRequest takes a rate limiter token for every outbound request,
+// including each endpoint tried during failover.
+func sendRequest(req *http.Request) (*http.Response, error) {
+	if err := apiLimiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	return httpClient.Do(req)
+}
+
+// doWithFailover sends req to the active endpoint and moves on to the next
+// one on connection errors or 5xx responses, remembering the first that works.
+func doWithFailover(req *http.Request) (*http.Response, error) {
+	if len(apiEndpoints) <= 1 {
+		return sendRequest(req)
+	}
+
+	path := strings.TrimPrefix(req.URL.String(), apiBaseURL)
+	active := int(activeEndpoint.Load())
+
+	var resp *http.Response
+	var err error
+	for i := range apiEndpoints {
+		index := (active + i) % len(apiEndpoints)
+
+		r := req.Clone(req.Context())
+		if r.URL, err = url.Parse(apiEndpoints[index] + path); err != nil {
+			return nil, err
+		}
+		r.Host = ""
+
+		resp, err = sendRequest(r)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			if index != active {
+				slog.Warn("Switched NGENIX API endpoint", "endpoint", redactURL(apiEndpoints[index]), "index", index)
+				activeEndpoint.Store(int64(index))
+				activeEndpointIndex.Set(float64(index))
+			}
+			return resp, nil
+		}
+		if req.Context().Err() != nil || i == len(apiEndpoints)-1 {
+			break
+		}
+
+		if err != nil {
+			slog.Warn("NGENIX API endpoint failed, trying next", "endpoint", redactURL(apiEndpoints[index]), "err", err)
+		} else {
+			slog.Warn("NGENIX API endpoint failed, trying next", "endpoint", redactURL(apiEndpoints[index]), "status_code", resp.StatusCode)
+			resp.Body.Close()
+		}
+	}
+
+	return resp, err
+}
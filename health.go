@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+type collectorStatus struct {
+	mu          sync.Mutex
+	lastSuccess map[string]time.Time
+}
+
+var status = &collectorStatus{
+	lastSuccess: make(map[string]time.Time),
+}
+
+func (s *collectorStatus) recordSuccess(collector string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSuccess[collector] = t
+	lastSuccessTimestamp.WithLabelValues(collector).Set(float64(t.Unix()))
+}
+
+func (s *collectorStatus) ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.lastSuccess {
+		if !t.IsZero() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if !status.ready() {
+		http.Error(w, "no successful fetch yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
@@ -2,13 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"net/url"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,16 +14,49 @@ import (
 )
 
 var (
+	realtimeRequestsByCode        *prometheus.GaugeVec
+	realtimeBandwidthByCode       *prometheus.GaugeVec
+	realtimeRequestsByStatusClass *prometheus.GaugeVec
+	httpStatusCategoriesCount     *prometheus.GaugeVec
+)
+
+func setupHTTPStatusMetrics() {
 	realtimeRequestsByCode = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Namespace: "ngenix",
-			Subsystem: "realtime",
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
 			Name:      "requests_by_code",
 			Help:      "Realtime requests grouped by code",
 		},
-		[]string{"code"},
+		[]string{"configId", "config_name", "model_name", "code"},
 	)
-)
+	realtimeBandwidthByCode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "bandwidth_by_code",
+			Help:      "Realtime bandwidth grouped by code",
+		},
+		[]string{"configId", "config_name", "model_name", "code"},
+	)
+	realtimeRequestsByStatusClass = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "requests_by_status_class",
+			Help:      "Realtime requests grouped by status class",
+		},
+		[]string{"configId", "config_name", "model_name", "class"},
+	)
+	httpStatusCategoriesCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "httpstatus_categories_count",
+			Help:      "Number of status codes returned by the last httpstatus response",
+		},
+		[]string{"configId", "config_name"},
+	)
+}
 
 type httpStatusResponse struct {
 	Query struct {
@@ -42,94 +73,196 @@ type httpStatusResponse struct {
 	Categories []struct {
 		Name    string `json:"name"`
 		Metrics struct {
-			RealtimeRequests int `json:"realtimeRequests"`
+			RealtimeRequests  int   `json:"realtimeRequests"`
+			RealtimeBandwidth int64 `json:"realtimeBandwidth"`
 		} `json:"metrics"`
 	} `json:"categories"`
 	ModelName string `json:"modelName"`
 }
 
-func fetchRealtimeRequestsByCode() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+type httpStatusCollector struct {
+	configIDs   []string
+	seenCodes   *labelTracker
+	seenClasses *labelTracker
+}
+
+func newHTTPStatusCollector(configIDs []string) *httpStatusCollector {
+	return &httpStatusCollector{
+		configIDs:   configIDs,
+		seenCodes:   newLabelTracker(realtimeRequestsByCode, realtimeBandwidthByCode),
+		seenClasses: newLabelTracker(realtimeRequestsByStatusClass),
+	}
+}
 
-	for range ticker.C {
+func (c *httpStatusCollector) Collect(ctx context.Context) error {
+	return forEachConfig(ctx, c.configIDs, func(configID string) error {
 		var httpStatus httpStatusResponse
-		if err := fetchDataHTTPStatus(&httpStatus); err != nil {
-			log.Printf("Error fetching data: %v", err)
-			continue
+		fallback, err := fetchWithFallback(collectorHTTPStatus, configID, reportDate(), &httpStatus,
+			func(date time.Time, data *httpStatusResponse) error {
+				return fetchDataHTTPStatus(ctx, configID, date, data)
+			},
+			func(data *httpStatusResponse) bool { return len(data.Categories) == 0 })
+		if err != nil {
+			slog.Error("Error fetching data", "collector", collectorHTTPStatus, "configId", configID, "err", err)
+			c.seenCodes.reset(configID)
+			c.seenClasses.reset(configID)
+			deleteConfigSeries(collectorHTTPStatus, configID, httpStatusCategoriesCount)
+			return fmt.Errorf("config %s: %w", configID, err)
 		}
+		status.recordSuccess(collectorHTTPStatus, time.Now())
+		previousDayFallback.WithLabelValues(collectorHTTPStatus, configID, configName(configID)).Set(boolToFloat(fallback))
+		httpStatusCategoriesCount.WithLabelValues(configID, configName(configID)).Set(float64(len(httpStatus.Categories)))
 
-		if httpStatus.ModelName == "" || httpStatus.Categories == nil {
-			log.Println("Incomplete data received")
-			continue
+		if httpStatus.Categories == nil {
+			slog.Warn("Incomplete data received", "collector", collectorHTTPStatus, "configId", configID)
+			return nil
 		}
 
+		model := modelLabel(httpStatus.ModelName)
+
+		total := 0
+		codes := make(map[string]struct{}, len(httpStatus.Categories))
+		classes := make(map[string]int)
 		for _, category := range httpStatus.Categories {
-			if category.Name == "" || category.Metrics.RealtimeRequests == 0 {
+			total += category.Metrics.RealtimeRequests
+			if category.Name == "" {
+				continue
+			}
+			if !validStatusCode(category.Name) {
+				slog.Warn("Invalid status code category", "collector", collectorHTTPStatus, "configId", configID, "category", category.Name)
+				if *httpStatusStrict {
+					continue
+				}
+			}
+			classes[statusClass(category.Name)] += category.Metrics.RealtimeRequests
+			if !statusCodeAllowed(category.Name) {
 				continue
 			}
 
-			metric := realtimeRequestsByCode.WithLabelValues(category.Name)
-			if metric != nil {
-				metric.Set(float64(category.Metrics.RealtimeRequests))
+			setSmoothed(realtimeRequestsByCode, float64(category.Metrics.RealtimeRequests), configID, configName(configID), model, category.Name)
+			if *collectBandwidth {
+				setSmoothed(realtimeBandwidthByCode, float64(category.Metrics.RealtimeBandwidth), configID, configName(configID), model, category.Name)
 			}
+			codes[category.Name] = struct{}{}
+		}
+		realtimeRequestsTotal.WithLabelValues(collectorHTTPStatus, configID, configName(configID)).Set(float64(total))
+		c.seenCodes.evictMissing(configID, model, codes)
+
+		current := make(map[string]struct{}, len(classes))
+		for class, requests := range classes {
+			setSmoothed(realtimeRequestsByStatusClass, float64(requests), configID, configName(configID), model, class)
+			current[class] = struct{}{}
+		}
+		c.seenClasses.evictMissing(configID, model, current)
+
+		return nil
+	})
+}
+
+var httpStatusCodeFilter []string
+
+func parseStatusCodeFilter(value string) ([]string, error) {
+	filter := splitList(value)
+	for _, entry := range filter {
+		if validStatusCode(entry) {
+			continue
+		}
+		if len(entry) == 3 && entry[0] >= '1' && entry[0] <= '5' && strings.EqualFold(entry[1:], "xx") {
+			continue
+		}
+		return nil, fmt.Errorf("expected a status code or class like 5xx, got %q", entry)
+	}
+
+	return filter, nil
+}
+
+func statusCodeAllowed(code string) bool {
+	if len(httpStatusCodeFilter) == 0 {
+		return true
+	}
+
+	class := statusClass(code)
+	for _, entry := range httpStatusCodeFilter {
+		if entry == code || strings.EqualFold(entry, class) {
+			return true
 		}
 	}
+
+	return false
+}
+
+func validStatusCode(code string) bool {
+	n, err := strconv.Atoi(code)
+	return err == nil && n >= 100 && n <= 599
+}
+
+func statusClass(code string) string {
+	if !validStatusCode(code) {
+		return "unknown"
+	}
+
+	n, _ := strconv.Atoi(code)
+	return strconv.Itoa(n/100) + "xx"
 }
 
-func fetchDataHTTPStatus(data *httpStatusResponse) error {
+func fetchDataHTTPStatus(ctx context.Context, configID string, date time.Time, data *httpStatusResponse) error {
 	if data == nil {
 		return errors.New("data parameter is nil")
 	}
 
-	username, password := os.Getenv("NGENIX_USERNAME"), os.Getenv("NGENIX_PASSWORD")
-	if username == "" || password == "" {
-		return errors.New("missing basic auth credentials")
+	key := cacheKey{collectorHTTPStatus, configID, date.In(apiLocation).Format(queryDateLayout)}
+	if cached, ok := apiCache.get(key); ok {
+		*data = cached.(httpStatusResponse)
+		return nil
 	}
 
-	configID := os.Getenv("NGENIX_CONFIG_ID")
-	date := time.Now()
 	metrics := []string{"realtimeRequests"}
+	if *collectBandwidth {
+		metrics = append(metrics, "realtimeBandwidth")
+	}
 
-	url := getHTTPStatusURL(configID, date, metrics)
-
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	url, err := getHTTPStatusURL(configID, date, metrics)
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return err
 	}
+	recordQueryDay(collectorHTTPStatus, date)
 
-	req.SetBasicAuth(username, password)
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
 
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
+	resp, err := doRequest(ctx, collectorHTTPStatus, url)
 	if err != nil {
-		return fmt.Errorf("error executing request: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
-	}
-
-	err = json.NewDecoder(resp.Body).Decode(data)
-	if err != nil {
-		return fmt.Errorf("error decoding response: %w", err)
+	if err := decodeResponse(resp, collectorHTTPStatus, data); err != nil {
+		return err
 	}
 
+	apiCache.set(key, *data)
 	return nil
 }
 
-func getHTTPStatusURL(configId string, date time.Time, metrics []string) string {
-	if configId == "" || date.IsZero() || metrics == nil {
-		return ""
+func getHTTPStatusURL(configId string, date time.Time, metrics []string) (string, error) {
+	if err := validateConfigID(configId); err != nil {
+		return "", err
 	}
+	if date.IsZero() {
+		return "", errors.New("missing report date")
+	}
+	if len(metrics) == 0 {
+		return "", errors.New("missing metrics")
+	}
+
+	date = date.In(apiLocation)
 
 	params := url.Values{}
 	params.Set("configId", configId)
-	params.Set("start", date.Format("2006-01-02")+"T00:00:00")
-	params.Set("end", date.Format("2006-01-02")+"T23:59:59")
+	year, month, day := date.Date()
+	params.Set("start", time.Date(year, month, day, 0, 0, 0, 0, apiLocation).Format(*apiTimeLayout))
+	params.Set("end", time.Date(year, month, day, 23, 59, 59, 0, apiLocation).Format(*apiTimeLayout))
 	params.Set("metrics", strings.Join(metrics, ","))
 
-	return fmt.Sprintf("https://api.ngenix.net/reports/v1/analytical/httpstatuses?%s", params.Encode())
+	return fmt.Sprintf("%s/reports/%s/analytical/httpstatuses?%s", apiBaseURL, *apiVersion, params.Encode()), nil
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetHTTPStatusURL(t *testing.T) {
+	setFlag(t, &apiBaseURL, "https://api.ngenix.net")
+
+	tests := []struct {
+		name    string
+		date    time.Time
+		metrics []string
+		want    string
+	}{
+		{
+			name:    "requests",
+			date:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			metrics: []string{"realtimeRequests"},
+			want:    "https://api.ngenix.net/reports/v1/analytical/httpstatuses?configId=123&end=2024-05-01T23%3A59%3A59&metrics=realtimeRequests&start=2024-05-01T00%3A00%3A00",
+		},
+		{
+			name:    "bandwidth late in the day",
+			date:    time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC),
+			metrics: []string{"realtimeRequests", "realtimeBandwidth"},
+			want:    "https://api.ngenix.net/reports/v1/analytical/httpstatuses?configId=123&end=2024-05-01T23%3A59%3A59&metrics=realtimeRequests%2CrealtimeBandwidth&start=2024-05-01T00%3A00%3A00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getHTTPStatusURL("123", tt.date, tt.metrics)
+			if err != nil {
+				t.Fatalf("getHTTPStatusURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getHTTPStatusURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPStatusZeroRequests(t *testing.T) {
+	resetMetrics(t, realtimeRequestsByCode, realtimeRequestsByStatusClass, httpStatusCategoriesCount)
+	c := newHTTPStatusCollector([]string{"1"})
+
+	for _, requests := range []string{"50", "0"} {
+		newFakeAPI(t, map[string]fixture{"/analytical/httpstatuses": {
+			body: `{"modelName": "cdn", "categories": [{"name": "500", "metrics": {"realtimeRequests": ` + requests + `}}]}`,
+		}})
+		if err := c.Collect(context.Background()); err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+	}
+
+	assertSeries(t, realtimeRequestsByCode, map[string]float64{"500,1,1,cdn": 0})
+}
+
+func TestStatusClass(t *testing.T) {
+	tests := []struct {
+		code  string
+		valid bool
+		class string
+	}{
+		{"200", true, "2xx"},
+		{"404", true, "4xx"},
+		{"599", true, "5xx"},
+		{"100", true, "1xx"},
+		{"600", false, "unknown"},
+		{"99", false, "unknown"},
+		{"other", false, "unknown"},
+		{"", false, "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := validStatusCode(tt.code); got != tt.valid {
+			t.Errorf("validStatusCode(%q) = %t, want %t", tt.code, got, tt.valid)
+		}
+		if got := statusClass(tt.code); got != tt.class {
+			t.Errorf("statusClass(%q) = %q, want %q", tt.code, got, tt.class)
+		}
+	}
+}
+
+func TestHTTPStatusStrict(t *testing.T) {
+	body := `{"modelName": "cdn", "categories": [
+		{"name": "200", "metrics": {"realtimeRequests": 10}},
+		{"name": "other", "metrics": {"realtimeRequests": 3}},
+		{"name": "999", "metrics": {"realtimeRequests": 2}}
+	]}`
+
+	tests := []struct {
+		name    string
+		strict  bool
+		want    map[string]float64
+		classes map[string]float64
+	}{
+		{
+			name:   "lenient",
+			strict: false,
+			want: map[string]float64{
+				"200,1,1,cdn":   10,
+				"other,1,1,cdn": 3,
+				"999,1,1,cdn":   2,
+			},
+			classes: map[string]float64{
+				"2xx,1,1,cdn":     10,
+				"unknown,1,1,cdn": 5,
+			},
+		},
+		{
+			name:    "strict",
+			strict:  true,
+			want:    map[string]float64{"200,1,1,cdn": 10},
+			classes: map[string]float64{"2xx,1,1,cdn": 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMetrics(t, realtimeRequestsByCode, realtimeRequestsByStatusClass, httpStatusCategoriesCount)
+			setFlag(t, httpStatusStrict, tt.strict)
+			newFakeAPI(t, map[string]fixture{"/analytical/httpstatuses": {body: body}})
+
+			if err := newHTTPStatusCollector([]string{"1"}).Collect(context.Background()); err != nil {
+				t.Fatalf("Collect() error = %v", err)
+			}
+
+			assertSeries(t, realtimeRequestsByCode, tt.want)
+			assertSeries(t, realtimeRequestsByStatusClass, tt.classes)
+			assertSeries(t, realtimeRequestsTotal, map[string]float64{"httpstatus,1,1": 15})
+		})
+	}
+}
+
+func TestParseStatusCodeFilter(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "5xx", want: []string{"5xx"}},
+		{value: "404, 5XX", want: []string{"404", "5XX"}},
+		{value: "6xx", wantErr: true},
+		{value: "4x", wantErr: true},
+		{value: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseStatusCodeFilter(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseStatusCodeFilter(%q) error = %v, wantErr %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("parseStatusCodeFilter(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestStatusCodeAllowed(t *testing.T) {
+	tests := []struct {
+		filter []string
+		code   string
+		want   bool
+	}{
+		{filter: nil, code: "200", want: true},
+		{filter: []string{"5xx"}, code: "503", want: true},
+		{filter: []string{"5XX"}, code: "500", want: true},
+		{filter: []string{"5xx"}, code: "404", want: false},
+		{filter: []string{"404", "5xx"}, code: "404", want: true},
+		{filter: []string{"404"}, code: "403", want: false},
+		{filter: []string{"5xx"}, code: "other", want: false},
+	}
+
+	for _, tt := range tests {
+		setFlag(t, &httpStatusCodeFilter, tt.filter)
+		if got := statusCodeAllowed(tt.code); got != tt.want {
+			t.Errorf("statusCodeAllowed(%q) with filter %q = %t, want %t", tt.code, tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPStatusCodeFilter(t *testing.T) {
+	resetMetrics(t, realtimeRequestsByCode, realtimeRequestsByStatusClass, httpStatusCategoriesCount)
+	setFlag(t, &httpStatusCodeFilter, []string{"5xx"})
+	newFakeAPI(t, map[string]fixture{"/analytical/httpstatuses": {file: "httpstatus.json"}})
+
+	if err := newHTTPStatusCollector([]string{"1"}).Collect(context.Background()); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	assertSeries(t, realtimeRequestsByCode, map[string]float64{"503,1,1,cdn": 5})
+	assertSeries(t, realtimeRequestsByStatusClass, map[string]float64{
+		"2xx,1,1,cdn": 900,
+		"4xx,1,1,cdn": 30,
+		"5xx,1,1,cdn": 5,
+	})
+}
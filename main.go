@@ -1,29 +1,524 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-const (
-	listenAddress = ":8080"
+var (
+	configFilePath = flag.String("config.file", "", "Path to a YAML (.yaml, .yml) or JSON configuration file; flags and environment variables override its values")
+	listenAddress  = flag.String("web.listen-address", ":8080", "Address to listen on for HTTP requests")
+
+	showVersion = flag.Bool("version", false, "Print version information and exit")
+	check       = flag.Bool("check", false, "Fetch every enabled collector once, report the result and exit")
+	once        = flag.Bool("once", false, "Fetch every enabled collector once, print the metrics to stdout and exit")
+
+	scrapeInterval              = flag.Duration("scrape.interval", 30*time.Second, "Interval between timeline report fetches")
+	scrapeTimeout               = flag.Duration("scrape.timeout", 15*time.Second, "Timeout for a NGENIX API fetch, including retries")
+	scrapeJitter                = flag.Duration("scrape.jitter", 0, "Maximum random delay before the first fetch of each collector, 0 uses the collector interval")
+	scrapeConnectTimeout        = flag.Duration("scrape.connect-timeout", 5*time.Second, "Timeout for establishing a connection to the NGENIX API")
+	scrapeResponseHeaderTimeout = flag.Duration("scrape.response-header-timeout", 10*time.Second, "Timeout for receiving NGENIX API response headers after sending a request")
+	scrapeConcurrency           = flag.Int("scrape.concurrency", 4, "Maximum number of configs fetched in parallel by each collector")
+	scrapeMaxRequestsPerSecond  = flag.Float64("scrape.max-requests-per-second", 0, "Maximum rate of NGENIX API requests across all collectors, 0 means unlimited")
+	scrapeMaxAttempts           = flag.Int("scrape.max-attempts", 3, "Maximum number of attempts for a NGENIX API request on transient failures")
+	scrapeOnDemand              = flag.Bool("scrape.on-demand", false, "Query the top100 and httpstatus endpoints on each /metrics scrape instead of in the background")
+	scrapeCacheTTL              = flag.Duration("scrape.cache-ttl", 5*time.Second, "How long on-demand results are reused across /metrics scrapes")
+	scrapeMaxBodyBytes          = flag.Int64("scrape.max-body-bytes", 10<<20, "Maximum size of a NGENIX API response body in bytes")
+
+	cacheTTL = flag.Duration("cache.ttl", 0, "How long decoded NGENIX API responses are cached, 0 disables the cache")
+
+	webAuthUsername     = flag.String("web.auth-username", "", "Username required to access /metrics, enables basic authentication")
+	webAuthPassword     = flag.String("web.auth-password", "", "Password required to access /metrics")
+	webAuthPasswordFile = flag.String("web.auth-password-file", "", "File containing the password required to access /metrics")
+
+	webTLSCertFile = flag.String("web.tls-cert-file", "", "PEM certificate file for serving the exporter over HTTPS")
+	webTLSKeyFile  = flag.String("web.tls-key-file", "", "PEM private key file for serving the exporter over HTTPS")
+
+	metricsNamespace = flag.String("metrics.namespace", "ngenix", "Namespace prefix of exported metric names")
+	metricsSubsystem = flag.String("metrics.subsystem", "realtime", "Subsystem of exported NGENIX data metric names")
+
+	enableConfigEndpoint = flag.Bool("web.enable-config-endpoint", false, "Serve the effective configuration as JSON on /config, with credentials redacted")
+	exposeRuntimeMetrics = flag.Bool("web.expose-runtime-metrics", false, "Expose Go runtime and process metrics")
+
+	httpMaxIdleConnsPerHost = flag.Int("http.max-idle-conns-per-host", 4, "Maximum number of idle keep-alive connections to the NGENIX API")
+	httpIdleConnTimeout     = flag.Duration("http.idle-conn-timeout", 90*time.Second, "How long an idle keep-alive connection to the NGENIX API is kept open")
+
+	apiDateLayout    = flag.String("ngenix.date-layout", queryDateLayout, "Go time layout of date query parameters sent to the NGENIX API")
+	apiTimeLayout    = flag.String("ngenix.time-layout", timelineTimeLayout, "Go time layout of start and end query parameters sent to the NGENIX API")
+	apiEndpointsList = flag.String("ngenix.api-endpoints", "", "Comma-separated NGENIX API base URLs tried in order on connection errors or 5xx responses, NGENIX_API_BASE_URL when empty")
+	apiVersion       = flag.String("ngenix.api-version", "v1", "Version segment of the NGENIX reports API path; all collectors are built against the v1 schema")
+
+	proxyURL = flag.String("proxy.url", "", "Proxy URL for NGENIX API requests, overrides HTTP_PROXY/HTTPS_PROXY")
+
+	tlsCAFile             = flag.String("tls.ca-file", "", "PEM file with CA certificates for the NGENIX API endpoint")
+	tlsInsecureSkipVerify = flag.Bool("tls.insecure-skip-verify", false, "Skip TLS certificate verification for the NGENIX API endpoint")
+
+	logFormat = flag.String("log.format", "text", "Log format, one of: text, json")
+	logLevel  = flag.String("log.level", "info", "Log level, one of: debug, info, warn, error")
+
+	auditFile       = flag.String("log.audit-file", "", "Write a JSON line for every NGENIX API request to this file, disabled when empty")
+	auditMaxSize    = flag.Int("log.audit-max-size", 100, "Size in megabytes at which the audit file is rotated")
+	auditMaxAge     = flag.Duration("log.audit-max-age", 0, "Remove rotated audit files older than this, 0 keeps them regardless of age")
+	auditMaxBackups = flag.Int("log.audit-max-backups", 3, "Number of rotated audit files to keep, 0 keeps all")
+
+	timezone = flag.String("timezone", "UTC", "Timezone used for dates in NGENIX API queries")
+
+	queryStartDate = flag.String("query.start-date", "", "Start of a timeline range to fetch once and exit, as 2006-01-02 or 2006-01-02T15:04:05")
+	queryEndDate   = flag.String("query.end-date", "", "End of a timeline range to fetch once and exit, as 2006-01-02 or 2006-01-02T15:04:05")
+	queryDateFlag  = flag.String("query.date", "", "Day to fetch once and exit, as 2006-01-02; defaults to the day of -query.end-date")
+
+	timelineWindow         = flag.Duration("timeline.window", time.Hour, "Length of the timeline report window")
+	timelineEndOffset      = flag.Duration("timeline.end-offset", 0, "Offset of the timeline report window end from the current time")
+	timelineAPIInterval    = flag.Int("timeline.api-interval", 30, "Timeline data point interval in seconds; above -scrape.interval consecutive fetches see the same points")
+	timelineSummaryOnly    = flag.Bool("timeline.summary-only", false, "Only export the timeline summary gauges and skip processing per-interval data; the API has no summary-only query, so the full timeline is still requested")
+	timelineDropZeroStatus = flag.Bool("timeline.drop-zero-status", true, "Skip timeline values with httpStatus 0, which NGENIX uses for unclassified rows")
+	timelineMetrics        = flag.String("timeline.metrics", "realtimeRequests", "Comma-separated list of timeline metrics to request; metrics other than realtimeRequests get their own gauge, and the max/min/avg summary gauges only cover traffic")
+
+	collectorsEnabled = flag.String("collectors.enabled", "", "Comma-separated list of collectors to run, or all; overrides the individual -collector.<name> flags")
+	enableTimeline    = flag.Bool("collector.timeline", true, "Enable the timeline report collector")
+	enableTop100      = flag.Bool("collector.top100", true, "Enable the top100 requests by path collector")
+	enableHTTPStatus  = flag.Bool("collector.httpstatus", true, "Enable the requests by HTTP status collector")
+	enableCacheStatus = flag.Bool("collector.cachestatus", false, "Enable the requests by cache status collector")
+	enableCountries   = flag.Bool("collector.countries", false, "Enable the requests by country collector, polled at the top100 interval")
+	enableUserAgents  = flag.Bool("collector.useragents", false, "Enable the top user agents collector, limited like top100 and polled at its interval")
+	enableReferers    = flag.Bool("collector.referers", false, "Enable the top referers collector, limited like top100 and polled at its interval")
+
+	top100Interval     = flag.Duration("collector.top100.interval", 5*time.Second, "Interval between top100 fetches")
+	httpStatusInterval = flag.Duration("collector.httpstatus.interval", 5*time.Second, "Interval between httpstatus fetches")
+
+	seriesTTL = flag.Duration("collector.series-ttl", 0, "Remove timeline, path, code and cache status series not updated within this duration, 0 disables expiry")
+
+	smoothingAlpha = flag.Float64("collector.smoothing-alpha", 0, "Exponential moving average factor in (0, 1] applied to realtime request gauges, 0 exports raw values")
+
+	httpStatusCodes  = flag.String("collector.httpstatus.codes", "", "Comma-separated status codes or classes like 5xx to export per code, all when empty")
+	httpStatusStrict = flag.Bool("collector.httpstatus.strict", false, "Skip httpstatus categories whose name is not a valid HTTP status code")
+
+	dayOffset           = flag.Int("collector.day-offset", 0, "Number of days before today to query the daily analytical endpoints for, 1 fetches yesterday's complete data")
+	fallbackPreviousDay = flag.Bool("collector.fallback-previous-day", false, "Serve the previous day's top100 and httpstatus data when the current day's response has no categories")
+
+	collectBandwidth = flag.Bool("collector.bandwidth", false, "Request and export bandwidth for the top100 and httpstatus collectors")
+
+	userAgentsClassify = flag.Bool("collector.useragents.classify", false, "Group user agents into bot, browser and unknown families")
+
+	top100ResetMissing       = flag.Bool("collector.top100.reset-missing", true, "Remove path series missing from the latest top100 response")
+	top100Limit              = flag.Int("collector.top100.limit", 100, "Maximum number of top100 paths to export, ordered by request count")
+	top100StripQuery         = flag.Bool("collector.top100.strip-query", false, "Strip query strings from top100 paths")
+	top100PathRegexReplace   = flag.String("collector.top100.path-regex-replace", "", "Rewrite top100 paths matching a regex, in the form <regex>=<replacement>")
+	top100Include            = flag.String("collector.top100.include-regex", "", "Only export top100 paths matching this regex")
+	top100Exclude            = flag.String("collector.top100.exclude-regex", "", "Do not export top100 paths matching this regex")
+	top100StreamingDecode    = flag.Bool("collector.top100.streaming-decode", true, "Decode top100 responses category by category and keep only the exported paths; disable to buffer and decode the whole body at once. The decode duration histogram includes network reads when enabled")
+	top100AggregateRemainder = flag.Bool("collector.top100.aggregate-remainder", false, "Aggregate top100 paths beyond the limit into a single __other__ series")
 )
 
 func main() {
-	prometheus.MustRegister(realtimeRequestsByPath)
-	prometheus.MustRegister(realtimeRequestsByCode)
+	flag.Parse()
 
-	//go fetchRealtimeRequestsByPath()
-	//go fetchRealtimeRequestsByCode()
-	go fetchReport()
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
+	logger, err := newLogger(*logFormat, *logLevel)
+	if err != nil {
+		fatal("Invalid logging configuration", "err", err)
+	}
+	slog.SetDefault(logger)
 
-	log.Printf("HTTP server listening on %s", listenAddress)
-	if err := http.ListenAndServe(listenAddress, nil); err != nil {
-		log.Fatalf("Error starting HTTP server: %v", err)
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if err := applyFlagEnv(flag.CommandLine, explicit); err != nil {
+		fatal("Invalid environment variable", "err", err)
+	}
+
+	if *auditMaxSize < 1 {
+		fatal("Invalid audit log max size", "size", *auditMaxSize)
+	}
+	if *auditMaxAge < 0 || *auditMaxBackups < 0 {
+		fatal("Invalid audit log retention", "max_age", *auditMaxAge, "max_backups", *auditMaxBackups)
+	}
+	if err := setupAuditLog(*auditFile, *auditMaxSize, *auditMaxAge, *auditMaxBackups); err != nil {
+		fatal("Invalid audit log configuration", "err", err)
+	}
+
+	if *configFilePath != "" {
+		configFile, err = loadConfigFile(*configFilePath)
+		if err != nil {
+			fatal("Error loading config file", "err", err)
+		}
+
+		if err := applyConfigFile(configFile, explicit); err != nil {
+			fatal("Invalid config file", "path", *configFilePath, "err", err)
+		}
+	}
+
+	if *collectorsEnabled != "" {
+		enabled, err := parseEnabledCollectors(*collectorsEnabled)
+		if err != nil {
+			fatal("Invalid enabled collectors", "err", err)
+		}
+		*enableTimeline = enabled[collectorTimeline]
+		*enableTop100 = enabled[collectorTop100]
+		*enableHTTPStatus = enabled[collectorHTTPStatus]
+		*enableCacheStatus = enabled[collectorCacheStatus]
+		*enableReferers = enabled[collectorReferers]
+		*enableUserAgents = enabled[collectorUserAgents]
+		*enableCountries = enabled[collectorCountries]
+	}
+
+	if !metricNamePattern.MatchString(*metricsNamespace) {
+		fatal("Invalid metrics namespace", "namespace", *metricsNamespace)
+	}
+	if *metricsSubsystem != "" && !metricNamePattern.MatchString(*metricsSubsystem) {
+		fatal("Invalid metrics subsystem", "subsystem", *metricsSubsystem)
 	}
+	setupMetrics()
+
+	if !apiVersionPattern.MatchString(*apiVersion) {
+		fatal("Invalid NGENIX API version", "version", *apiVersion)
+	}
+	if err := setupAPIEndpoints(*apiEndpointsList); err != nil {
+		fatal("Invalid NGENIX API endpoints", "err", err)
+	}
+	if err := validateTimeLayout(*apiDateLayout); err != nil {
+		fatal("Invalid NGENIX API date layout", "layout", *apiDateLayout, "err", err)
+	}
+	if err := validateTimeLayout(*apiTimeLayout); err != nil {
+		fatal("Invalid NGENIX API time layout", "layout", *apiTimeLayout, "err", err)
+	}
+	if !slices.Contains(knownAPIVersions, *apiVersion) {
+		slog.Warn("Untested NGENIX API version, responses are decoded with the v1 schema", "version", *apiVersion, "known", knownAPIVersions)
+	}
+
+	if *scrapeInterval <= 0 {
+		fatal("Invalid scrape interval", "interval", *scrapeInterval)
+	}
+
+	if *top100Interval <= 0 {
+		fatal("Invalid top100 interval", "interval", *top100Interval)
+	}
+
+	if *httpStatusInterval <= 0 {
+		fatal("Invalid httpstatus interval", "interval", *httpStatusInterval)
+	}
+
+	if *scrapeTimeout <= 0 {
+		fatal("Invalid scrape timeout", "timeout", *scrapeTimeout)
+	}
+
+	webPassword := *webAuthPassword
+	if *webAuthPasswordFile != "" {
+		data, err := os.ReadFile(*webAuthPasswordFile)
+		if err != nil {
+			fatal("Error reading web auth password file", "err", err)
+		}
+		webPassword = strings.TrimRight(string(data), " \t\r\n")
+	}
+	if (*webAuthUsername == "") != (webPassword == "") {
+		fatal("Web auth username and password must be set together")
+	}
+
+	var serverTLSConfig *tls.Config
+	if *webTLSCertFile != "" || *webTLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*webTLSCertFile, *webTLSKeyFile)
+		if err != nil {
+			fatal("Error loading web TLS certificate", "err", err)
+		}
+		serverTLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	if *scrapeJitter < 0 {
+		fatal("Invalid scrape jitter", "jitter", *scrapeJitter)
+	}
+
+	if *scrapeConnectTimeout <= 0 {
+		fatal("Invalid scrape connect timeout", "timeout", *scrapeConnectTimeout)
+	}
+
+	if *scrapeResponseHeaderTimeout <= 0 {
+		fatal("Invalid scrape response header timeout", "timeout", *scrapeResponseHeaderTimeout)
+	}
+
+	if *scrapeConcurrency < 1 {
+		fatal("Invalid scrape concurrency", "concurrency", *scrapeConcurrency)
+	}
+
+	if *scrapeMaxRequestsPerSecond < 0 {
+		fatal("Invalid scrape max requests per second", "rate", *scrapeMaxRequestsPerSecond)
+	}
+
+	if *scrapeMaxAttempts < 1 {
+		fatal("Invalid scrape max attempts", "attempts", *scrapeMaxAttempts)
+	}
+
+	if *httpMaxIdleConnsPerHost < 1 {
+		fatal("Invalid max idle connections per host", "conns", *httpMaxIdleConnsPerHost)
+	}
+
+	if *httpIdleConnTimeout < 0 {
+		fatal("Invalid idle connection timeout", "timeout", *httpIdleConnTimeout)
+	}
+
+	if *scrapeMaxBodyBytes <= 0 {
+		fatal("Invalid scrape max body bytes", "bytes", *scrapeMaxBodyBytes)
+	}
+
+	if *timelineWindow <= 0 {
+		fatal("Invalid timeline window", "window", *timelineWindow)
+	}
+
+	if *timelineEndOffset < 0 {
+		fatal("Invalid timeline end offset", "offset", *timelineEndOffset)
+	}
+
+	if *dayOffset < 0 {
+		fatal("Invalid day offset", "offset", *dayOffset)
+	}
+
+	if *seriesTTL < 0 {
+		fatal("Invalid series TTL", "ttl", *seriesTTL)
+	}
+
+	if *smoothingAlpha < 0 || *smoothingAlpha > 1 {
+		fatal("Invalid smoothing alpha", "alpha", *smoothingAlpha)
+	}
+
+	if *top100Limit < 1 {
+		fatal("Invalid top100 limit", "limit", *top100Limit)
+	}
+
+	if *top100Include != "" {
+		if top100IncludeRegex, err = regexp.Compile(*top100Include); err != nil {
+			fatal("Invalid top100 include regex", "err", err)
+		}
+	}
+
+	if *top100Exclude != "" {
+		if top100ExcludeRegex, err = regexp.Compile(*top100Exclude); err != nil {
+			fatal("Invalid top100 exclude regex", "err", err)
+		}
+	}
+
+	httpStatusCodeFilter, err = parseStatusCodeFilter(*httpStatusCodes)
+	if err != nil {
+		fatal("Invalid httpstatus codes", "err", err)
+	}
+
+	if *top100PathRegexReplace != "" {
+		top100PathRegex, top100PathReplacement, err = parsePathRegexReplace(*top100PathRegexReplace)
+		if err != nil {
+			fatal("Invalid top100 path regex replace", "err", err)
+		}
+	}
+
+	if !validTimelineAPIInterval(*timelineAPIInterval) {
+		fatal("Invalid timeline API interval", "interval", *timelineAPIInterval, "allowed", timelineAPIIntervals)
+	}
+
+	metrics := splitList(*timelineMetrics)
+	if len(metrics) == 0 {
+		fatal("Invalid timeline metrics", "metrics", *timelineMetrics)
+	}
+	if err := setupTimelineMetrics(metrics); err != nil {
+		fatal("Invalid timeline metrics", "err", err)
+	}
+
+	httpClient, err = newHTTPClient(clientConfig{
+		Timeout:            *scrapeTimeout,
+		ProxyURL:           *proxyURL,
+		CAFile:             *tlsCAFile,
+		InsecureSkipVerify: *tlsInsecureSkipVerify,
+
+		MaxIdleConnsPerHost: *httpMaxIdleConnsPerHost,
+		IdleConnTimeout:     *httpIdleConnTimeout,
+
+		ConnectTimeout:        *scrapeConnectTimeout,
+		ResponseHeaderTimeout: *scrapeResponseHeaderTimeout,
+	})
+	if err != nil {
+		fatal("Error creating HTTP client", "err", err)
+	}
+	apiLimiter = newTokenBucket(*scrapeMaxRequestsPerSecond, 1)
+
+	apiCache = newResponseCache(*cacheTTL)
+
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		fatal("Invalid timezone", "timezone", *timezone, "err", err)
+	}
+	apiLocation = loc
+
+	if err := setupQueryRange(*queryStartDate, *queryEndDate, *queryDateFlag); err != nil {
+		fatal("Invalid query range", "err", err)
+	}
+
+	configIDs, err := getConfigIDs()
+	if err != nil {
+		fatal("Error reading configuration", "err", err)
+	}
+
+	if *check {
+		if !runCheck(context.Background(), configIDs) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *exposeRuntimeMetrics {
+		registry.MustRegister(collectors.NewGoCollector())
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	var scheduled []scheduledCollector
+	if *enableTimeline {
+		scheduled = append(scheduled, scheduledCollector{name: collectorTimeline, interval: *scrapeInterval, collector: &timelineCollector{configIDs: configIDs}})
+	}
+
+	if *enableTop100 {
+		c := scheduledCollector{name: collectorTop100, interval: *top100Interval, collector: newTop100Collector(configIDs)}
+		metrics := []prometheus.Collector{realtimeRequestsByPath, top100CategoriesCount}
+		if *collectBandwidth {
+			metrics = append(metrics, realtimeBandwidthByPath)
+		}
+
+		if *scrapeOnDemand {
+			registry.MustRegister(newOnDemandCollector(ctx, c, *scrapeCacheTTL, metrics...))
+		} else {
+			registry.MustRegister(metrics...)
+			scheduled = append(scheduled, c)
+		}
+	}
+
+	if *enableHTTPStatus {
+		c := scheduledCollector{name: collectorHTTPStatus, interval: *httpStatusInterval, collector: newHTTPStatusCollector(configIDs)}
+		metrics := []prometheus.Collector{realtimeRequestsByCode, realtimeRequestsByStatusClass, httpStatusCategoriesCount}
+		if *collectBandwidth {
+			metrics = append(metrics, realtimeBandwidthByCode)
+		}
+
+		if *scrapeOnDemand {
+			registry.MustRegister(newOnDemandCollector(ctx, c, *scrapeCacheTTL, metrics...))
+		} else {
+			registry.MustRegister(metrics...)
+			scheduled = append(scheduled, c)
+		}
+	}
+
+	if *enableCacheStatus {
+		registry.MustRegister(realtimeRequestsByCacheStatus)
+		scheduled = append(scheduled, scheduledCollector{name: collectorCacheStatus, interval: *scrapeInterval, collector: newCacheStatusCollector(configIDs)})
+	}
+
+	if *enableReferers {
+		registry.MustRegister(realtimeRequestsByReferer)
+		scheduled = append(scheduled, scheduledCollector{name: collectorReferers, interval: *top100Interval, collector: newRefererCollector(configIDs)})
+	}
+
+	if *enableUserAgents {
+		registry.MustRegister(realtimeRequestsByUserAgent)
+		scheduled = append(scheduled, scheduledCollector{name: collectorUserAgents, interval: *top100Interval, collector: newUserAgentCollector(configIDs)})
+	}
+
+	if *enableCountries {
+		registry.MustRegister(realtimeRequestsByCountry)
+		scheduled = append(scheduled, scheduledCollector{name: collectorCountries, interval: *top100Interval, collector: newCountryCollector(configIDs)})
+	}
+
+	for _, c := range scheduled {
+		scrapeIntervalSeconds.WithLabelValues(c.name).Set(c.interval.Seconds())
+	}
+
+	if *once || backfill() {
+		if err := runOnce(ctx, scheduled, os.Stdout); err != nil {
+			fatal("Error printing metrics", "err", err)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runScheduler(ctx, scheduled)
+	}()
+
+	if *seriesTTL > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runSeriesSweeper(ctx, *seriesTTL)
+		}()
+	}
+
+	mux := http.NewServeMux()
+	var metricsHandler http.Handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if *webAuthUsername != "" {
+		metricsHandler = basicAuth(metricsHandler, *webAuthUsername, webPassword)
+	}
+	mux.Handle("/metrics", instrumentHandler("/metrics", metricsHandler))
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/ready", readyHandler)
+	if *enableConfigEndpoint {
+		var handler http.Handler = configHandler(configIDs)
+		if *webAuthUsername != "" {
+			handler = basicAuth(handler, *webAuthUsername, webPassword)
+		}
+		mux.Handle("/config", handler)
+	}
+
+	server := &http.Server{
+		Addr:      *listenAddress,
+		Handler:   mux,
+		TLSConfig: serverTLSConfig,
+	}
+
+	go func() {
+		slog.Info("HTTP server listening", "address", *listenAddress, "tls", serverTLSConfig != nil)
+
+		var err error
+		if serverTLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Error starting HTTP server", "err", err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	slog.Info("shutting down")
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down HTTP server", "err", err)
+	}
+
+	wg.Wait()
+	slog.Info("stopped")
 }
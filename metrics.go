@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	collectorTimeline    = "timeline"
+	collectorHTTPStatus  = "httpstatus"
+	collectorTop100      = "top100"
+	collectorCacheStatus = "cachestatus"
+	collectorReferers    = "referers"
+	collectorUserAgents  = "useragents"
+	collectorCountries   = "countries"
+)
+
+var collectorNames = []string{
+	collectorTimeline,
+	collectorTop100,
+	collectorHTTPStatus,
+	collectorCacheStatus,
+	collectorReferers,
+	collectorUserAgents,
+	collectorCountries,
+}
+
+var registry = prometheus.NewRegistry()
+
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var (
+	collectorUp           *prometheus.GaugeVec
+	scrapeDuration        *prometheus.HistogramVec
+	scrapeErrors          *prometheus.CounterVec
+	apiResponseCodes      *prometheus.CounterVec
+	lastSuccessTimestamp  *prometheus.GaugeVec
+	cacheHits             prometheus.Counter
+	cacheMisses           prometheus.Counter
+	realtimeRequestsTotal *prometheus.GaugeVec
+	scrapeIntervalSeconds *prometheus.GaugeVec
+	exporterHTTPRequests  *prometheus.CounterVec
+	exporterHTTPDuration  *prometheus.HistogramVec
+	decodeDuration        *prometheus.HistogramVec
+	collectorPanics       *prometheus.CounterVec
+	rateLimited           prometheus.Counter
+	throttledRequests     prometheus.Counter
+	previousDayFallback   *prometheus.GaugeVec
+	authFailures          *prometheus.CounterVec
+	queryWindowStart      *prometheus.GaugeVec
+	queryWindowEnd        *prometheus.GaugeVec
+	activeEndpointIndex   prometheus.Gauge
+)
+
+func setupMetrics() {
+	collectorUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "up",
+			Help:      "Whether the last NGENIX API fetch succeeded",
+		},
+		[]string{"collector"},
+	)
+	scrapeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: *metricsNamespace,
+			Name:      "scrape_duration_seconds",
+			Help:      "Duration of NGENIX API fetches",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"collector"},
+	)
+	scrapeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Name:      "scrape_errors_total",
+			Help:      "Total number of failed NGENIX API fetches",
+		},
+		[]string{"collector"},
+	)
+	apiResponseCodes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Name:      "api_response_code_total",
+			Help:      "Total number of NGENIX API responses by HTTP status code",
+		},
+		[]string{"collector", "code"},
+	)
+	lastSuccessTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful NGENIX API fetch",
+		},
+		[]string{"collector"},
+	)
+	cacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Name:      "cache_hits_total",
+			Help:      "Total number of NGENIX API responses served from the cache",
+		},
+	)
+	cacheMisses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Name:      "cache_misses_total",
+			Help:      "Total number of NGENIX API cache lookups that required a request",
+		},
+	)
+	realtimeRequestsTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "requests_total",
+			Help:      "Realtime requests summed over all categories of the last response",
+		},
+		[]string{"collector", "configId", "config_name"},
+	)
+	scrapeIntervalSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "scrape_interval_seconds",
+			Help:      "Configured interval between NGENIX API fetches",
+		},
+		[]string{"collector"},
+	)
+	exporterHTTPRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: "exporter",
+			Name:      "http_requests_total",
+			Help:      "Total number of HTTP requests served by the exporter",
+		},
+		[]string{"handler", "code"},
+	)
+	exporterHTTPDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: "exporter",
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests served by the exporter",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"handler"},
+	)
+	decodeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: *metricsNamespace,
+			Name:      "decode_duration_seconds",
+			Help:      "Duration of decoding NGENIX API response bodies, excluding network time",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		},
+		[]string{"collector"},
+	)
+	collectorPanics = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Name:      "collector_panics_total",
+			Help:      "Total number of recovered panics in NGENIX collectors",
+		},
+		[]string{"collector"},
+	)
+	rateLimited = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Name:      "rate_limited_total",
+			Help:      "Total number of NGENIX API responses with status 429",
+		},
+	)
+	authFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Name:      "auth_failures_total",
+			Help:      "Total number of NGENIX API responses with status 401 or 403",
+		},
+		[]string{"collector"},
+	)
+	queryWindowStart = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "query_window_start_timestamp_seconds",
+			Help:      "Start of the time range used in the last NGENIX API request",
+		},
+		[]string{"collector"},
+	)
+	queryWindowEnd = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "query_window_end_timestamp_seconds",
+			Help:      "End of the time range used in the last NGENIX API request",
+		},
+		[]string{"collector"},
+	)
+	activeEndpointIndex = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "api_active_endpoint_index",
+			Help:      "Index in -ngenix.api-endpoints of the NGENIX API endpoint currently in use",
+		},
+	)
+	previousDayFallback = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "previous_day_fallback",
+			Help:      "Whether the collector is serving the previous day's data because the current day's response was empty",
+		},
+		[]string{"collector", "configId", "config_name"},
+	)
+	throttledRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: *metricsNamespace,
+			Name:      "api_requests_throttled_total",
+			Help:      "Total number of NGENIX API requests delayed by -scrape.max-requests-per-second",
+		},
+	)
+
+	registry.MustRegister(collectorUp)
+	registry.MustRegister(scrapeDuration)
+	registry.MustRegister(scrapeErrors)
+	registry.MustRegister(apiResponseCodes)
+	registry.MustRegister(lastSuccessTimestamp)
+	registry.MustRegister(rateLimited)
+	registry.MustRegister(authFailures)
+	registry.MustRegister(throttledRequests)
+	registry.MustRegister(previousDayFallback)
+	registry.MustRegister(queryWindowStart)
+	registry.MustRegister(queryWindowEnd)
+	registry.MustRegister(activeEndpointIndex)
+	registry.MustRegister(cacheHits)
+	registry.MustRegister(cacheMisses)
+	registry.MustRegister(realtimeRequestsTotal)
+	registry.MustRegister(collectorPanics)
+	registry.MustRegister(decodeDuration)
+	registry.MustRegister(scrapeIntervalSeconds)
+	registry.MustRegister(exporterHTTPRequests)
+	registry.MustRegister(exporterHTTPDuration)
+
+	setupTop100Metrics()
+	setupHTTPStatusMetrics()
+	setupCacheStatusMetrics()
+	setupRefererMetrics()
+	setupUserAgentMetrics()
+	setupCountryMetrics()
+	setupBuildInfo()
+}
+
+func instrumentHandler(handler string, next http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": handler}
+	return promhttp.InstrumentHandlerCounter(
+		exporterHTTPRequests.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(exporterHTTPDuration.MustCurryWith(labels), next),
+	)
+}
+
+func observeScrape(collector string, start time.Time, err error) {
+	scrapeDuration.WithLabelValues(collector).Observe(time.Since(start).Seconds())
+	if err != nil {
+		scrapeErrors.WithLabelValues(collector).Inc()
+	}
+}
+
+func setCollectorUp(collector string, up bool) {
+	if up {
+		collectorUp.WithLabelValues(collector).Set(1)
+	} else {
+		collectorUp.WithLabelValues(collector).Set(0)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type labelTracker struct {
+	mu          sync.Mutex
+	gauges      []*prometheus.GaugeVec
+	seen        map[string]map[string]trackedSeries
+	labelValues func(configID, model, label string) []string
+}
+
+type trackedSeries struct {
+	model   string
+	updated time.Time
+}
+
+var (
+	labelTrackersMu sync.Mutex
+	labelTrackers   []*labelTracker
+)
+
+func newLabelTracker(gauges ...*prometheus.GaugeVec) *labelTracker {
+	t := &labelTracker{
+		gauges:      gauges,
+		seen:        make(map[string]map[string]trackedSeries),
+		labelValues: trackedLabelValues,
+	}
+
+	labelTrackersMu.Lock()
+	labelTrackers = append(labelTrackers, t)
+	labelTrackersMu.Unlock()
+
+	return t
+}
+
+func deleteConfigSeries(collector, configID string, gauges ...*prometheus.GaugeVec) {
+	for _, gauge := range gauges {
+		gauge.DeletePartialMatch(prometheus.Labels{"configId": configID})
+	}
+	realtimeRequestsTotal.DeletePartialMatch(prometheus.Labels{"collector": collector, "configId": configID})
+	previousDayFallback.DeletePartialMatch(prometheus.Labels{"collector": collector, "configId": configID})
+}
+
+func (t *labelTracker) evictMissing(configID, model string, current map[string]struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for label := range t.seen[configID] {
+		if _, ok := current[label]; !ok {
+			t.delete(configID, label)
+		}
+	}
+	t.record(configID, model, current)
+}
+
+func (t *labelTracker) touch(configID, model string, current map[string]struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.record(configID, model, current)
+}
+
+func (t *labelTracker) reset(configID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for label := range t.seen[configID] {
+		t.delete(configID, label)
+	}
+}
+
+func (t *labelTracker) expire(now time.Time, ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for configID, labels := range t.seen {
+		for label, series := range labels {
+			if now.Sub(series.updated) > ttl {
+				t.delete(configID, label)
+			}
+		}
+	}
+}
+
+func (t *labelTracker) record(configID, model string, current map[string]struct{}) {
+	if t.seen[configID] == nil {
+		t.seen[configID] = make(map[string]trackedSeries, len(current))
+	}
+
+	now := time.Now()
+	for label := range current {
+		if series, ok := t.seen[configID][label]; ok && series.model != model {
+			t.delete(configID, label)
+		}
+		t.seen[configID][label] = trackedSeries{model: model, updated: now}
+	}
+}
+
+func (t *labelTracker) delete(configID, label string) {
+	labels := t.labelValues(configID, t.seen[configID][label].model, label)
+	for _, gauge := range t.gauges {
+		gauge.DeleteLabelValues(labels...)
+		smoothing.forget(gauge, labels...)
+	}
+	delete(t.seen[configID], label)
+}
+
+func trackedLabelValues(configID, model, label string) []string {
+	return []string{configID, configName(configID), model, label}
+}
+
+func modelLabel(name string) string {
+	if name == "" {
+		return "unknown"
+	}
+
+	return name
+}
+
+func runSeriesSweeper(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(max(ttl/10, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			labelTrackersMu.Lock()
+			trackers := slices.Clone(labelTrackers)
+			labelTrackersMu.Unlock()
+
+			for _, t := range trackers {
+				t.expire(now, ttl)
+			}
+		}
+	}
+}
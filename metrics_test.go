@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestModelLabel(t *testing.T) {
+	tests := []struct {
+		fixture, want string
+	}{
+		{"top100.json", "1,1,cdn,/index.html"},
+		{"top100_no_model.json", "1,1,unknown,/index.html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			resetMetrics(t, realtimeRequestsByPath, top100CategoriesCount)
+			newFakeAPI(t, map[string]fixture{"/analytical/top100": {file: tt.fixture}})
+
+			if err := newTop100Collector([]string{"1"}).Collect(context.Background()); err != nil {
+				t.Fatalf("Collect() error = %v", err)
+			}
+
+			if _, ok := collectSeries(t, realtimeRequestsByPath)[tt.want]; !ok {
+				t.Errorf("missing series {%s}, got %v", tt.want, collectSeries(t, realtimeRequestsByPath))
+			}
+		})
+	}
+}
+
+func TestModelLabelHTTPStatus(t *testing.T) {
+	resetMetrics(t, realtimeRequestsByCode, realtimeRequestsByStatusClass, httpStatusCategoriesCount)
+	newFakeAPI(t, map[string]fixture{"/analytical/httpstatuses": {file: "httpstatus_no_model.json"}})
+
+	if err := newHTTPStatusCollector([]string{"1"}).Collect(context.Background()); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	assertSeries(t, realtimeRequestsByCode, map[string]float64{"200,1,1,unknown": 900})
+}
+
+func TestModelLabelTimeline(t *testing.T) {
+	resetMetrics(t, trafficGauge)
+
+	var report Report
+	err := json.Unmarshal([]byte(`{"data": [{"values": [
+		{"groupedBy": {"httpStatus": 200, "modelName": "cdn"}, "metrics": {"realtimeTraffic": 10}},
+		{"groupedBy": {"httpStatus": 200}, "metrics": {"realtimeTraffic": 4}}
+	]}]}`), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &timelineCollector{}
+	c.processReport("1", &report)
+
+	for _, model := range []string{"cdn", "unknown"} {
+		labels := prometheus.Labels{"configId": "1", "config_name": "1", "httpStatus": "200", "model_name": model}
+		if !trafficGauge.Delete(labels) {
+			t.Errorf("missing series %v, got %v", labels, collectSeries(t, trafficGauge))
+		}
+	}
+}
+
+func TestSmoothingRollups(t *testing.T) {
+	setFlag(t, smoothingAlpha, 0.5)
+	resetMetrics(t, realtimeRequestsByCacheStatus, realtimeRequestsByCode, realtimeRequestsByStatusClass, httpStatusCategoriesCount)
+
+	cacheStatus := newCacheStatusCollector([]string{"1"})
+	httpStatus := newHTTPStatusCollector([]string{"1"})
+	collect := func(status, code, requests string) {
+		t.Helper()
+
+		newFakeAPI(t, map[string]fixture{
+			"/timeline/configs?groupBy=cacheStatus": {body: `{"modelName": "cdn", "data": [{"values": [{"groupedBy": {"cacheStatus": "` + status + `"}, "metrics": {"realtimeRequests": ` + requests + `}}]}]}`},
+			"/analytical/httpstatuses":              {body: `{"modelName": "cdn", "categories": [{"name": "` + code + `", "metrics": {"realtimeRequests": ` + requests + `}}]}`},
+		})
+		if err := cacheStatus.Collect(context.Background()); err != nil {
+			t.Fatalf("cache status Collect() error = %v", err)
+		}
+		if err := httpStatus.Collect(context.Background()); err != nil {
+			t.Fatalf("httpstatus Collect() error = %v", err)
+		}
+	}
+
+	collect("HIT", "200", "100")
+	collect("HIT", "200", "50")
+	assertSeries(t, realtimeRequestsByCacheStatus, map[string]float64{"HIT,1,1,cdn": 75})
+	assertSeries(t, realtimeRequestsByStatusClass, map[string]float64{"2xx,1,1,cdn": 75})
+
+	// An evicted series starts over instead of blending with its old value.
+	collect("MISS", "500", "8")
+	collect("HIT", "200", "10")
+	assertSeries(t, realtimeRequestsByCacheStatus, map[string]float64{"HIT,1,1,cdn": 10})
+	assertSeries(t, realtimeRequestsByStatusClass, map[string]float64{"2xx,1,1,cdn": 10})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func runOnce(ctx context.Context, collectors []scheduledCollector, w io.Writer) error {
+	for _, c := range collectors {
+		runCollector(ctx, c)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		return fmt.Errorf("error gathering metrics: %w", err)
+	}
+
+	enc := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, family := range families {
+		if err := enc.Encode(family); err != nil {
+			return fmt.Errorf("error encoding metrics: %w", err)
+		}
+	}
+
+	return nil
+}
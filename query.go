@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const queryDateLayout = "2006-01-02"
+
+var queryStart, queryEnd, queryDate time.Time
+
+// validateTimeLayout rejects layouts without any time directives, which
+// would send the layout itself as a literal query parameter.
+func validateTimeLayout(layout string) error {
+	if layout == "" {
+		return errors.New("empty layout")
+	}
+
+	reference := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if reference.Format(layout) == layout {
+		return fmt.Errorf("layout %q has no time elements", layout)
+	}
+
+	return nil
+}
+
+func parseQueryTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation(timelineTimeLayout, value, apiLocation); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation(queryDateLayout, value, apiLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected %s or %s, got %q", queryDateLayout, timelineTimeLayout, value)
+	}
+
+	return t, nil
+}
+
+func setupQueryRange(start, end, date string) error {
+	if (start == "") != (end == "") {
+		return errors.New("start and end dates must be set together")
+	}
+
+	var err error
+	if start != "" {
+		if queryStart, err = parseQueryTime(start); err != nil {
+			return fmt.Errorf("start date: %w", err)
+		}
+		if queryEnd, err = parseQueryTime(end); err != nil {
+			return fmt.Errorf("end date: %w", err)
+		}
+		if !queryStart.Before(queryEnd) {
+			return fmt.Errorf("start date %s is not before end date %s", start, end)
+		}
+		queryDate = queryEnd
+	}
+
+	if date != "" {
+		if queryDate, err = time.ParseInLocation(queryDateLayout, date, apiLocation); err != nil {
+			return fmt.Errorf("date: %w", err)
+		}
+		if queryStart.IsZero() {
+			queryStart, queryEnd = queryDate, queryDate.AddDate(0, 0, 1)
+		}
+	}
+
+	return nil
+}
+
+func backfill() bool {
+	return !queryDate.IsZero()
+}
+
+func reportDate() time.Time {
+	if backfill() {
+		return queryDate
+	}
+
+	return time.Now().In(apiLocation).AddDate(0, 0, -*dayOffset)
+}
+
+// fetchWithFallback fetches date and, when enabled and the result is empty,
+// retries exactly once with the day before. It reports whether the previous
+// day's data was used.
+func fetchWithFallback[T any](collector, configID string, date time.Time, data *T, fetch func(time.Time, *T) error, empty func(*T) bool) (bool, error) {
+	if err := fetch(date, data); err != nil || !empty(data) || !*fallbackPreviousDay || backfill() {
+		return false, err
+	}
+
+	previous := date.AddDate(0, 0, -1)
+	var fallback T
+	if err := fetch(previous, &fallback); err != nil {
+		slog.Warn("Error fetching previous day data", "collector", collector, "configId", configID, "date", previous.Format(queryDateLayout), "err", err)
+		recordQueryDay(collector, date)
+		return false, nil
+	}
+	if empty(&fallback) {
+		recordQueryDay(collector, date)
+		return false, nil
+	}
+
+	slog.Debug("Serving previous day data", "collector", collector, "configId", configID, "date", previous.Format(queryDateLayout))
+	*data = fallback
+	return true, nil
+}
+
+func recordQueryWindow(collector string, start, end time.Time) {
+	queryWindowStart.WithLabelValues(collector).Set(float64(start.Unix()))
+	queryWindowEnd.WithLabelValues(collector).Set(float64(end.Unix()))
+}
+
+// recordQueryDay records the whole day the daily analytical endpoints report on.
+func recordQueryDay(collector string, date time.Time) {
+	year, month, day := date.In(apiLocation).Date()
+	start := time.Date(year, month, day, 0, 0, 0, 0, apiLocation)
+	recordQueryWindow(collector, start, start.AddDate(0, 0, 1))
+}
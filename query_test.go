@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLTimezone(t *testing.T) {
+	instant := time.Date(2024, 5, 1, 22, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		loc        *time.Location
+		wantDate   string
+		wantWindow string
+	}{
+		{"UTC", time.UTC, "date=2024-05-01", "start=2024-05-01T21:30:00&end=2024-05-01T22:30:00"},
+		{"UTC+3", time.FixedZone("UTC+3", 3*60*60), "date=2024-05-02", "start=2024-05-02T00:30:00&end=2024-05-02T01:30:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, &apiLocation, tt.loc)
+
+			top100, err := getTop100URL("1", instant, []string{"realtimeRequests"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(top100, tt.wantDate) {
+				t.Errorf("top100 URL %s does not contain %s", top100, tt.wantDate)
+			}
+
+			timeline, err := buildReportURL("1", instant.Add(-time.Hour), instant, []string{"realtimeTraffic"}, 30, "httpStatus")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(timeline, tt.wantWindow) {
+				t.Errorf("timeline URL %s does not contain %s", timeline, tt.wantWindow)
+			}
+		})
+	}
+}
+
+func TestURLBuilderErrors(t *testing.T) {
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	metrics := []string{"realtimeRequests"}
+
+	builders := map[string]func(configID string, date time.Time, metrics []string) (string, error){
+		"timeline": func(configID string, date time.Time, metrics []string) (string, error) {
+			return buildReportURL(configID, date, date.Add(time.Hour), metrics, 30, "httpStatus")
+		},
+		"httpstatus": getHTTPStatusURL,
+		"top100":     getTop100URL,
+		"analytical": func(configID string, date time.Time, metrics []string) (string, error) {
+			return getAnalyticalURL("referers", configID, date, metrics)
+		},
+	}
+
+	tests := []struct {
+		name     string
+		configID string
+		date     time.Time
+		metrics  []string
+	}{
+		{"empty config id", "", date, metrics},
+		{"zero date", "1", time.Time{}, metrics},
+		{"nil metrics", "1", date, nil},
+	}
+
+	for builder, build := range builders {
+		for _, tt := range tests {
+			t.Run(builder+"/"+tt.name, func(t *testing.T) {
+				u, err := build(tt.configID, tt.date, tt.metrics)
+				if err == nil {
+					t.Errorf("got URL %s, want error", u)
+				}
+				if u != "" {
+					t.Errorf("got URL %s with error, want empty", u)
+				}
+			})
+		}
+	}
+}
+
+func TestDayOffset(t *testing.T) {
+	setFlag(t, dayOffset, 1)
+
+	want := time.Now().In(apiLocation).AddDate(0, 0, -1).Format(queryDateLayout)
+	if got := reportDate().Format(queryDateLayout); got != want {
+		t.Errorf("reportDate() = %s, want %s", got, want)
+	}
+
+	resetMetrics(t, realtimeRequestsByPath, top100CategoriesCount)
+	api := newFakeAPI(t, map[string]fixture{"/analytical/top100": {file: "top100.json"}})
+	if err := newTop100Collector([]string{"1"}).Collect(context.Background()); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if got := api.requests[0].Query().Get("date"); got != want {
+		t.Errorf("requested date = %s, want %s", got, want)
+	}
+
+	setFlag(t, &queryDate, time.Date(2024, 5, 1, 0, 0, 0, 0, apiLocation))
+	if got := reportDate().Format(queryDateLayout); got != "2024-05-01" {
+		t.Errorf("reportDate() with -query.date = %s, want 2024-05-01", got)
+	}
+}
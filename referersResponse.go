@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var realtimeRequestsByReferer *prometheus.GaugeVec
+
+func setupRefererMetrics() {
+	realtimeRequestsByReferer = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "requests_by_referer",
+			Help:      "Realtime requests grouped by referer",
+		},
+		[]string{"configId", "config_name", "model_name", "referer"},
+	)
+}
+
+type refererCollector struct {
+	configIDs    []string
+	seenReferers *labelTracker
+}
+
+func newRefererCollector(configIDs []string) *refererCollector {
+	return &refererCollector{
+		configIDs:    configIDs,
+		seenReferers: newLabelTracker(realtimeRequestsByReferer),
+	}
+}
+
+func (c *refererCollector) Collect(ctx context.Context) error {
+	return forEachConfig(ctx, c.configIDs, func(configID string) error {
+		var response top100Response
+		if err := fetchAnalyticalTopList(ctx, collectorReferers, "referers", configID, &response); err != nil {
+			slog.Error("Error fetching data", "collector", collectorReferers, "configId", configID, "err", err)
+			c.seenReferers.reset(configID)
+			return fmt.Errorf("config %s: %w", configID, err)
+		}
+		status.recordSuccess(collectorReferers, time.Now())
+
+		if response.Categories == nil {
+			slog.Warn("Incomplete data received", "collector", collectorReferers, "configId", configID)
+			return nil
+		}
+
+		model := modelLabel(response.ModelName)
+
+		categories := limitCategories(response.Categories, *top100Limit, *top100AggregateRemainder)
+
+		referers := make(map[string]struct{}, len(categories))
+		for _, category := range categories {
+			if category.Name == "" {
+				continue
+			}
+
+			setSmoothed(realtimeRequestsByReferer, float64(category.Metrics.RealtimeRequests), configID, configName(configID), model, category.Name)
+			referers[category.Name] = struct{}{}
+		}
+		c.seenReferers.evictMissing(configID, model, referers)
+
+		return nil
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The smoothed value lags the raw one, so PromQL functions like rate() or
+// avg_over_time() applied on top average an already averaged series.
+type smoother struct {
+	mu   sync.Mutex
+	prev map[smoothKey]float64
+}
+
+type smoothKey struct {
+	gauge  *prometheus.GaugeVec
+	labels string
+}
+
+var smoothing = &smoother{prev: make(map[smoothKey]float64)}
+
+func (s *smoother) apply(gauge *prometheus.GaugeVec, value float64, labels []string) float64 {
+	alpha := *smoothingAlpha
+	if alpha <= 0 {
+		return value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := smoothKey{gauge, strings.Join(labels, "\xff")}
+	if prev, ok := s.prev[key]; ok {
+		value = alpha*value + (1-alpha)*prev
+	}
+	s.prev[key] = value
+
+	return value
+}
+
+func (s *smoother) forget(gauge *prometheus.GaugeVec, labels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.prev, smoothKey{gauge, strings.Join(labels, "\xff")})
+}
+
+func setSmoothed(gauge *prometheus.GaugeVec, value float64, labels ...string) {
+	gauge.WithLabelValues(labels...).Set(smoothing.apply(gauge, value, labels))
+}
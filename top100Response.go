@@ -1,14 +1,16 @@
 package main
 
 import (
+	"container/heap"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"net/url"
-	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,16 +18,39 @@ import (
 )
 
 var (
+	realtimeRequestsByPath  *prometheus.GaugeVec
+	realtimeBandwidthByPath *prometheus.GaugeVec
+	top100CategoriesCount   *prometheus.GaugeVec
+)
+
+func setupTop100Metrics() {
 	realtimeRequestsByPath = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Namespace: "ngenix",
-			Subsystem: "realtime",
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
 			Name:      "requests_by_path",
 			Help:      "Realtime requests grouped by path",
 		},
-		[]string{"path"},
+		[]string{"configId", "config_name", "model_name", "path"},
 	)
-)
+	realtimeBandwidthByPath = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "bandwidth_by_path",
+			Help:      "Realtime bandwidth grouped by path",
+		},
+		[]string{"configId", "config_name", "model_name", "path"},
+	)
+	top100CategoriesCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Name:      "top100_categories_count",
+			Help:      "Number of paths returned by the last top100 response",
+		},
+		[]string{"configId", "config_name"},
+	)
+}
 
 type top100Response struct {
 	Query struct {
@@ -38,100 +63,483 @@ type top100Response struct {
 		Date      string        `json:"date"`
 		ModelName string        `json:"modelName"`
 	} `json:"query"`
-	Categories []struct {
-		Name    string `json:"name"`
-		Metrics struct {
-			RealtimeRequests int `json:"realtimeRequests"`
-		} `json:"metrics"`
-	} `json:"categories"`
-	ModelName string `json:"modelName"`
+	Categories []top100Category `json:"categories"`
+	ModelName  string           `json:"modelName"`
+
+	// Set by the streaming decoder, which keeps only the categories that
+	// are exported and counts the rest as they are read.
+	selected bool
+	count    int
+	total    int
+}
+
+// exported returns the number of categories in the response, their total
+// requests and the normalized, filtered and limited categories to export.
+func (r *top100Response) exported() (int, int, []top100Category) {
+	if r.selected {
+		return r.count, r.total, r.Categories
+	}
+
+	total := 0
+	for _, category := range r.Categories {
+		total += category.Metrics.RealtimeRequests
+	}
+
+	categories := filterCategories(normalizeCategories(r.Categories))
+	return len(r.Categories), total, limitCategories(categories, *top100Limit, *top100AggregateRemainder)
 }
 
-func fetchRealtimeRequestsByPath() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+type top100Category struct {
+	Name    string `json:"name"`
+	Metrics struct {
+		RealtimeRequests  int   `json:"realtimeRequests"`
+		RealtimeBandwidth int64 `json:"realtimeBandwidth"`
+	} `json:"metrics"`
+}
 
-	for range ticker.C {
+const otherPath = "__other__"
+
+var (
+	top100PathRegex       *regexp.Regexp
+	top100PathReplacement string
+
+	top100IncludeRegex *regexp.Regexp
+	top100ExcludeRegex *regexp.Regexp
+)
+
+type top100Collector struct {
+	configIDs []string
+	seenPaths *labelTracker
+}
+
+func newTop100Collector(configIDs []string) *top100Collector {
+	return &top100Collector{
+		configIDs: configIDs,
+		seenPaths: newLabelTracker(realtimeRequestsByPath, realtimeBandwidthByPath),
+	}
+}
+
+func (c *top100Collector) Collect(ctx context.Context) error {
+	return forEachConfig(ctx, c.configIDs, func(configID string) error {
 		var response top100Response
-		if err := fetchDataTOP100(&response); err != nil {
-			log.Printf("Error fetching data: %v", err)
-			continue
+		fallback, err := fetchWithFallback(collectorTop100, configID, reportDate(), &response,
+			func(date time.Time, data *top100Response) error {
+				return fetchDataTOP100(ctx, configID, date, data)
+			},
+			func(data *top100Response) bool {
+				count, _, _ := data.exported()
+				return count == 0
+			})
+		if err != nil {
+			slog.Error("Error fetching data", "collector", collectorTop100, "configId", configID, "err", err)
+			c.seenPaths.reset(configID)
+			deleteConfigSeries(collectorTop100, configID, top100CategoriesCount)
+			return fmt.Errorf("config %s: %w", configID, err)
 		}
+		status.recordSuccess(collectorTop100, time.Now())
+		previousDayFallback.WithLabelValues(collectorTop100, configID, configName(configID)).Set(boolToFloat(fallback))
+		count, total, categories := response.exported()
+		top100CategoriesCount.WithLabelValues(configID, configName(configID)).Set(float64(count))
 
-		if response.ModelName == "" || response.Categories == nil {
-			log.Println("Incomplete data received")
-			continue
+		if response.Categories == nil {
+			slog.Warn("Incomplete data received", "collector", collectorTop100, "configId", configID)
+			return nil
 		}
 
-		for _, category := range response.Categories {
-			if category.Name == "" || category.Metrics.RealtimeRequests == 0 {
-				log.Printf("Invalid category: %v", category)
+		model := modelLabel(response.ModelName)
+
+		realtimeRequestsTotal.WithLabelValues(collectorTop100, configID, configName(configID)).Set(float64(total))
+
+		paths := make(map[string]struct{}, len(categories))
+		for _, category := range categories {
+			if category.Name == "" {
+				slog.Warn("Invalid category", "collector", collectorTop100, "configId", configID, "category", category)
 				continue
 			}
 
-			if v := realtimeRequestsByPath.WithLabelValues(category.Name); v != nil {
-				v.Set(float64(category.Metrics.RealtimeRequests))
+			setSmoothed(realtimeRequestsByPath, float64(category.Metrics.RealtimeRequests), configID, configName(configID), model, category.Name)
+			if *collectBandwidth {
+				setSmoothed(realtimeBandwidthByPath, float64(category.Metrics.RealtimeBandwidth), configID, configName(configID), model, category.Name)
 			}
+			paths[category.Name] = struct{}{}
+		}
+
+		if *top100ResetMissing {
+			c.seenPaths.evictMissing(configID, model, paths)
+		} else {
+			c.seenPaths.touch(configID, model, paths)
 		}
+
+		return nil
+	})
+}
+
+func parsePathRegexReplace(value string) (*regexp.Regexp, string, error) {
+	i := strings.LastIndex(value, "=")
+	if i < 0 {
+		return nil, "", fmt.Errorf("expected <regex>=<replacement>, got %q", value)
 	}
+
+	re, err := regexp.Compile(value[:i])
+	if err != nil {
+		return nil, "", err
+	}
+
+	return re, value[i+1:], nil
+}
+
+func normalizePath(path string) string {
+	if *top100StripQuery {
+		path, _, _ = strings.Cut(path, "?")
+	}
+
+	if top100PathRegex != nil {
+		path = top100PathRegex.ReplaceAllString(path, top100PathReplacement)
+	}
+
+	return strings.ToValidUTF8(path, "\uFFFD")
+}
+
+func normalizeCategories(categories []top100Category) []top100Category {
+	normalized := make([]top100Category, 0, len(categories))
+	index := make(map[string]int, len(categories))
+	for _, category := range categories {
+		if category.Name == "" {
+			normalized = append(normalized, category)
+			continue
+		}
+
+		category.Name = normalizePath(category.Name)
+		if i, ok := index[category.Name]; ok {
+			normalized[i].Metrics.RealtimeRequests += category.Metrics.RealtimeRequests
+			normalized[i].Metrics.RealtimeBandwidth += category.Metrics.RealtimeBandwidth
+			continue
+		}
+
+		index[category.Name] = len(normalized)
+		normalized = append(normalized, category)
+	}
+
+	return normalized
 }
 
-func fetchDataTOP100(data *top100Response) error {
+func filterCategories(categories []top100Category) []top100Category {
+	if top100IncludeRegex == nil && top100ExcludeRegex == nil {
+		return categories
+	}
+
+	filtered := categories[:0]
+	for _, category := range categories {
+		if pathAllowed(category.Name) {
+			filtered = append(filtered, category)
+		}
+	}
+
+	return filtered
+}
+
+func pathAllowed(path string) bool {
+	if top100IncludeRegex != nil && !top100IncludeRegex.MatchString(path) {
+		return false
+	}
+	if top100ExcludeRegex != nil && top100ExcludeRegex.MatchString(path) {
+		return false
+	}
+
+	return true
+}
+
+func limitCategories(categories []top100Category, limit int, aggregateRemainder bool) []top100Category {
+	if len(categories) <= limit {
+		return categories
+	}
+
+	sorted := slices.Clone(categories)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Metrics.RealtimeRequests > sorted[j].Metrics.RealtimeRequests
+	})
+
+	top := sorted[:limit]
+	if aggregateRemainder {
+		other := top100Category{Name: otherPath}
+		for _, category := range sorted[limit:] {
+			other.Metrics.RealtimeRequests += category.Metrics.RealtimeRequests
+			other.Metrics.RealtimeBandwidth += category.Metrics.RealtimeBandwidth
+		}
+		top = append(top, other)
+	}
+
+	return top
+}
+
+func fetchDataTOP100(ctx context.Context, configId string, date time.Time, data *top100Response) error {
 	if data == nil {
 		return errors.New("data parameter is nil")
 	}
 
-	username := os.Getenv("NGENIX_USERNAME")
-	password := os.Getenv("NGENIX_PASSWORD")
-	if username == "" || password == "" {
-		return errors.New("missing basic auth credentials")
+	key := cacheKey{collectorTop100, configId, date.In(apiLocation).Format(queryDateLayout)}
+	if cached, ok := apiCache.get(key); ok {
+		*data = cached.(top100Response)
+		return nil
 	}
 
-	configId := os.Getenv("NGENIX_CONFIG_ID")
-	date := time.Now()
 	metrics := []string{"realtimeRequests"}
+	if *collectBandwidth {
+		metrics = append(metrics, "realtimeBandwidth")
+	}
+
+	url, err := getTop100URL(configId, date, metrics)
+	if err != nil {
+		return err
+	}
+	recordQueryDay(collectorTop100, date)
 
-	url := getTop100URL(configId, date, metrics)
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	resp, err := doRequest(ctx, collectorTop100, url)
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return err
 	}
+	defer resp.Body.Close()
 
-	req.SetBasicAuth(username, password)
+	if *top100StreamingDecode {
+		err = decodeResponseStream(resp, collectorTop100, func(decoder *json.Decoder) error {
+			return decodeTop100(decoder, data)
+		})
+	} else {
+		err = decodeResponse(resp, collectorTop100, data)
+	}
+	if err != nil {
+		return err
+	}
 
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return nil
-		},
+	apiCache.set(key, *data)
+	return nil
+}
+
+// decodeTop100 reads the categories array one element at a time and keeps
+// only the categories that are exported, so neither the raw response body
+// nor the full category list is held in memory.
+func decodeTop100(decoder *json.Decoder, data *top100Response) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case "query":
+			err = decoder.Decode(&data.Query)
+		case "modelName":
+			err = decoder.Decode(&data.ModelName)
+		case "categories":
+			err = decodeTop100Categories(decoder, data)
+		default:
+			var skip json.RawMessage
+			err = decoder.Decode(&skip)
+		}
+		if err != nil {
+			return fmt.Errorf("field %v: %w", token, err)
+		}
 	}
 
-	resp, err := client.Do(req)
+	return expectDelim(decoder, '}')
+}
+
+func decodeTop100Categories(decoder *json.Decoder, data *top100Response) error {
+	token, err := decoder.Token()
 	if err != nil {
-		return fmt.Errorf("error executing request: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
+	if token == nil {
+		data.Categories = nil
+		return nil
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("expected array, got %v", token)
+	}
+
+	selector := newTop100Selector(*top100Limit)
+	for decoder.More() {
+		var category top100Category
+		if err := decoder.Decode(&category); err != nil {
+			return err
+		}
+		selector.add(category)
+	}
+	if err := expectDelim(decoder, ']'); err != nil {
+		return err
+	}
+
+	data.Categories = selector.categories(*top100AggregateRemainder)
+	data.selected, data.count, data.total = true, selector.count, selector.total
+	return nil
+}
+
+// top100Selector normalizes and filters categories as they are decoded and
+// keeps the busiest limit paths in a min-heap, folding the rest into the
+// remainder. A path evicted from the heap that shows up again later in the
+// response starts over, so its earlier requests stay in the remainder.
+type top100Selector struct {
+	limit int
+	heap  top100Heap
+	index map[string]*top100Entry
+	seq   int
+
+	count     int
+	total     int
+	evicted   bool
+	remainder top100Category
+}
+
+type top100Entry struct {
+	category top100Category
+	seq      int
+	pos      int
+}
+
+func newTop100Selector(limit int) *top100Selector {
+	return &top100Selector{
+		limit: limit,
+		index: make(map[string]*top100Entry, limit),
+	}
+}
+
+func (s *top100Selector) add(category top100Category) {
+	s.count++
+	s.total += category.Metrics.RealtimeRequests
+
+	if category.Name != "" {
+		category.Name = normalizePath(category.Name)
+		if entry, ok := s.index[category.Name]; ok {
+			entry.category.Metrics.RealtimeRequests += category.Metrics.RealtimeRequests
+			entry.category.Metrics.RealtimeBandwidth += category.Metrics.RealtimeBandwidth
+			heap.Fix(&s.heap, entry.pos)
+			return
+		}
+	}
+	if !pathAllowed(category.Name) {
+		return
+	}
+
+	entry := &top100Entry{category: category, seq: s.seq}
+	s.seq++
+	if len(s.heap) < s.limit {
+		heap.Push(&s.heap, entry)
+		s.track(entry)
+		return
+	}
+
+	if lowest := s.heap[0]; s.heap.less(lowest, entry) {
+		s.fold(lowest.category)
+		delete(s.index, lowest.category.Name)
+		entry.pos = 0
+		s.heap[0] = entry
+		heap.Fix(&s.heap, 0)
+		s.track(entry)
+		return
+	}
+	s.fold(category)
+}
+
+func (s *top100Selector) track(entry *top100Entry) {
+	if entry.category.Name != "" {
+		s.index[entry.category.Name] = entry
+	}
+}
+
+func (s *top100Selector) fold(category top100Category) {
+	s.evicted = true
+	s.remainder.Metrics.RealtimeRequests += category.Metrics.RealtimeRequests
+	s.remainder.Metrics.RealtimeBandwidth += category.Metrics.RealtimeBandwidth
+}
+
+// categories returns the selected paths ordered by request count, followed by
+// the remainder when aggregateRemainder is set and any path was left out.
+func (s *top100Selector) categories(aggregateRemainder bool) []top100Category {
+	entries := slices.Clone(s.heap)
+	sort.Slice(entries, func(i, j int) bool { return s.heap.less(entries[j], entries[i]) })
+
+	categories := make([]top100Category, 0, len(entries)+1)
+	for _, entry := range entries {
+		categories = append(categories, entry.category)
+	}
+	if aggregateRemainder && s.evicted {
+		other := s.remainder
+		other.Name = otherPath
+		categories = append(categories, other)
+	}
+
+	return categories
+}
+
+// top100Heap is a min-heap of paths by request count. Among equal counts the
+// later path sorts lower, matching the stable order of limitCategories.
+type top100Heap []*top100Entry
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+func (h top100Heap) less(a, b *top100Entry) bool {
+	if a.category.Metrics.RealtimeRequests != b.category.Metrics.RealtimeRequests {
+		return a.category.Metrics.RealtimeRequests < b.category.Metrics.RealtimeRequests
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(data); err != nil {
-		return fmt.Errorf("error decoding response: %w", err)
+	return a.seq > b.seq
+}
+
+func (h top100Heap) Len() int           { return len(h) }
+func (h top100Heap) Less(i, j int) bool { return h.less(h[i], h[j]) }
+
+func (h top100Heap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos, h[j].pos = i, j
+}
+
+func (h *top100Heap) Push(x any) {
+	entry := x.(*top100Entry)
+	entry.pos = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *top100Heap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
 	}
 
 	return nil
 }
 
-func getTop100URL(configId string, date time.Time, metrics []string) string {
-	if configId == "" || date.IsZero() || metrics == nil {
-		return ""
+func getTop100URL(configId string, date time.Time, metrics []string) (string, error) {
+	if err := validateConfigID(configId); err != nil {
+		return "", err
+	}
+	if date.IsZero() {
+		return "", errors.New("missing report date")
 	}
+	if len(metrics) == 0 {
+		return "", errors.New("missing metrics")
+	}
+
+	date = date.In(apiLocation)
 
 	params := url.Values{}
 	params.Set("configId", configId)
-	params.Set("date", date.Format("2006-01-02"))
+	params.Set("date", date.Format(*apiDateLayout))
 	params.Set("metrics", strings.Join(metrics, ","))
 
-	return fmt.Sprintf("https://api.ngenix.net/reports/v1/analytical/top100?%s", params.Encode())
+	return fmt.Sprintf("%s/reports/%s/analytical/top100?%s", apiBaseURL, *apiVersion, params.Encode()), nil
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestGetTop100URL(t *testing.T) {
+	setFlag(t, &apiBaseURL, "https://api.ngenix.net")
+
+	tests := []struct {
+		name    string
+		date    time.Time
+		metrics []string
+		want    string
+	}{
+		{
+			name:    "requests",
+			date:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			metrics: []string{"realtimeRequests"},
+			want:    "https://api.ngenix.net/reports/v1/analytical/top100?configId=123&date=2024-05-01&metrics=realtimeRequests",
+		},
+		{
+			name:    "bandwidth late in the day",
+			date:    time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC),
+			metrics: []string{"realtimeRequests", "realtimeBandwidth"},
+			want:    "https://api.ngenix.net/reports/v1/analytical/top100?configId=123&date=2024-05-01&metrics=realtimeRequests%2CrealtimeBandwidth",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getTop100URL("123", tt.date, tt.metrics)
+			if err != nil {
+				t.Fatalf("getTop100URL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("getTop100URL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTop100ZeroRequests(t *testing.T) {
+	resetMetrics(t, realtimeRequestsByPath, top100CategoriesCount)
+	c := newTop100Collector([]string{"1"})
+
+	for _, requests := range []string{"75", "0"} {
+		newFakeAPI(t, map[string]fixture{"/analytical/top100": {
+			body: `{"modelName": "cdn", "categories": [{"name": "/video.mp4", "metrics": {"realtimeRequests": ` + requests + `}}]}`,
+		}})
+		if err := c.Collect(context.Background()); err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+	}
+
+	assertSeries(t, realtimeRequestsByPath, map[string]float64{"1,1,cdn,/video.mp4": 0})
+}
+
+func TestTop100Decode(t *testing.T) {
+	body := `{"modelName": "cdn", "categories": [
+		{"name": "/a?x=1", "metrics": {"realtimeRequests": 50}},
+		{"name": "/a?x=2", "metrics": {"realtimeRequests": 30}},
+		{"name": "/b", "metrics": {"realtimeRequests": 70}},
+		{"name": "/c", "metrics": {"realtimeRequests": 10}},
+		{"name": "/admin/x", "metrics": {"realtimeRequests": 500}},
+		{"name": "/d", "metrics": {"realtimeRequests": 5}},
+		{"name": "", "metrics": {"realtimeRequests": 3}}
+	]}`
+
+	for _, streaming := range []bool{true, false} {
+		t.Run(fmt.Sprintf("streaming=%t", streaming), func(t *testing.T) {
+			resetMetrics(t, realtimeRequestsByPath, top100CategoriesCount)
+			setFlag(t, top100StreamingDecode, streaming)
+			setFlag(t, top100StripQuery, true)
+			setFlag(t, &top100ExcludeRegex, regexp.MustCompile("^/admin/"))
+			setFlag(t, top100Limit, 2)
+			setFlag(t, top100AggregateRemainder, true)
+			newFakeAPI(t, map[string]fixture{"/analytical/top100": {body: body}})
+
+			if err := newTop100Collector([]string{"1"}).Collect(context.Background()); err != nil {
+				t.Fatalf("Collect() error = %v", err)
+			}
+
+			assertSeries(t, realtimeRequestsByPath, map[string]float64{
+				"1,1,cdn,/a":        80,
+				"1,1,cdn,/b":        70,
+				"1,1,cdn,__other__": 18,
+			})
+			assertSeries(t, top100CategoriesCount, map[string]float64{"1,1": 7})
+			assertSeries(t, realtimeRequestsTotal, map[string]float64{"top100,1,1": 668})
+		})
+	}
+}
+
+func TestTop100SelectorBounded(t *testing.T) {
+	s := newTop100Selector(3)
+	for i := range 1000 {
+		var category top100Category
+		category.Name = fmt.Sprintf("/%d", i)
+		category.Metrics.RealtimeRequests = i % 97
+		s.add(category)
+
+		if len(s.heap) > 3 || len(s.index) > 3 {
+			t.Fatalf("selector holds %d entries and %d index keys, want at most 3", len(s.heap), len(s.index))
+		}
+	}
+
+	got := s.categories(false)
+	want := []string{"/96", "/193", "/290"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d categories, want %d", len(got), len(want))
+	}
+	for i, category := range got {
+		if category.Name != want[i] || category.Metrics.RealtimeRequests != 96 {
+			t.Errorf("category %d = %s (%d), want %s (96)", i, category.Name, category.Metrics.RealtimeRequests, want[i])
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var realtimeRequestsByUserAgent *prometheus.GaugeVec
+
+func setupUserAgentMetrics() {
+	realtimeRequestsByUserAgent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: *metricsSubsystem,
+			Name:      "requests_by_user_agent",
+			Help:      "Realtime requests grouped by user agent",
+		},
+		[]string{"configId", "config_name", "model_name", "user_agent"},
+	)
+}
+
+var botUserAgentMarkers = []string{"bot", "crawl", "spider", "slurp", "curl", "wget", "python", "go-http-client", "java/", "okhttp"}
+
+type userAgentCollector struct {
+	configIDs      []string
+	seenUserAgents *labelTracker
+}
+
+func newUserAgentCollector(configIDs []string) *userAgentCollector {
+	return &userAgentCollector{
+		configIDs:      configIDs,
+		seenUserAgents: newLabelTracker(realtimeRequestsByUserAgent),
+	}
+}
+
+func (c *userAgentCollector) Collect(ctx context.Context) error {
+	return forEachConfig(ctx, c.configIDs, func(configID string) error {
+		var response top100Response
+		if err := fetchAnalyticalTopList(ctx, collectorUserAgents, "useragents", configID, &response); err != nil {
+			slog.Error("Error fetching data", "collector", collectorUserAgents, "configId", configID, "err", err)
+			c.seenUserAgents.reset(configID)
+			return fmt.Errorf("config %s: %w", configID, err)
+		}
+		status.recordSuccess(collectorUserAgents, time.Now())
+
+		if response.Categories == nil {
+			slog.Warn("Incomplete data received", "collector", collectorUserAgents, "configId", configID)
+			return nil
+		}
+
+		model := modelLabel(response.ModelName)
+
+		categories := response.Categories
+		if *userAgentsClassify {
+			categories = classifyUserAgents(categories)
+		} else {
+			categories = limitCategories(categories, *top100Limit, *top100AggregateRemainder)
+		}
+
+		userAgents := make(map[string]struct{}, len(categories))
+		for _, category := range categories {
+			if category.Name == "" {
+				continue
+			}
+
+			setSmoothed(realtimeRequestsByUserAgent, float64(category.Metrics.RealtimeRequests), configID, configName(configID), model, category.Name)
+			userAgents[category.Name] = struct{}{}
+		}
+		c.seenUserAgents.evictMissing(configID, model, userAgents)
+
+		return nil
+	})
+}
+
+func classifyUserAgents(categories []top100Category) []top100Category {
+	families := make(map[string]*top100Category)
+	for _, category := range categories {
+		family := userAgentFamily(category.Name)
+		if f, ok := families[family]; ok {
+			f.Metrics.RealtimeRequests += category.Metrics.RealtimeRequests
+			f.Metrics.RealtimeBandwidth += category.Metrics.RealtimeBandwidth
+			continue
+		}
+
+		category.Name = family
+		families[family] = &category
+	}
+
+	classified := make([]top100Category, 0, len(families))
+	for _, f := range families {
+		classified = append(classified, *f)
+	}
+
+	return classified
+}
+
+func userAgentFamily(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	for _, marker := range botUserAgentMarkers {
+		if strings.Contains(ua, marker) {
+			return "bot"
+		}
+	}
+
+	if strings.HasPrefix(ua, "mozilla/") || strings.HasPrefix(ua, "opera/") {
+		return "browser"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	version   = "dev"
+	revision  = "unknown"
+	buildDate = "unknown"
+)
+
+var (
+	buildInfo *prometheus.GaugeVec
+)
+
+func setupBuildInfo() {
+	buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: *metricsNamespace,
+			Subsystem: "exporter",
+			Name:      "build_info",
+			Help:      "Build information of the NGENIX exporter",
+		},
+		[]string{"version", "revision", "goversion"},
+	)
+	registry.MustRegister(buildInfo)
+	buildInfo.WithLabelValues(version, revision, runtime.Version()).Set(1)
+}
+
+func userAgent() string {
+	return "ngenix-exporter/" + version
+}
+
+func versionString() string {
+	return fmt.Sprintf("ngenix-exporter version %s (revision: %s, build date: %s, go: %s)", version, revision, buildDate, runtime.Version())
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlToJSON converts the block-style YAML subset used by the config file to
+// JSON: nested mappings, sequences, plain and quoted scalars, comments and
+// JSON-style flow collections. Scalars are kept as strings, which matches
+// every field of fileConfig. Anchors, tags and multi-line scalars are not
+// supported.
+func yamlToJSON(data []byte) ([]byte, error) {
+	p := &yamlParser{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || len(p.lines) == 0 && text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(line) - len(text), text: text})
+	}
+
+	if len(p.lines) == 0 {
+		return []byte("{}"), nil
+	}
+
+	v, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+
+	return json.Marshal(v)
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) parseBlock(indent int) (any, error) {
+	if isYAMLSequenceItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseMapping(indent int) (any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+		if isYAMLSequenceItem(line.text) {
+			break
+		}
+
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\", got %q", line.number, line.text)
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.number, key)
+		}
+		p.pos++
+
+		if value != "" {
+			v, err := parseYAMLScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.number, err)
+			}
+			m[key] = v
+			continue
+		}
+
+		// A nested block is indented further, except that sequence items
+		// may start at the same indentation as their key.
+		switch {
+		case p.pos < len(p.lines) && p.lines[p.pos].indent > indent:
+			v, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSequenceItem(p.lines[p.pos].text):
+			v, err := p.parseSequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		default:
+			m[key] = nil
+		}
+	}
+
+	return m, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (any, error) {
+	items := []any{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !isYAMLSequenceItem(line.text) {
+			if line.indent > indent {
+				return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+			}
+			break
+		}
+
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if rest == "" {
+			p.pos++
+			if p.pos == len(p.lines) || p.lines[p.pos].indent <= indent {
+				items = append(items, nil)
+				continue
+			}
+			v, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			continue
+		}
+
+		if _, _, ok := splitYAMLKey(rest); ok || isYAMLSequenceItem(rest) {
+			// Parse "- key: value" as a block starting at the column of key.
+			p.lines[p.pos] = yamlLine{number: line.number, indent: indent + len(line.text) - len(rest), text: rest}
+			v, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			continue
+		}
+
+		v, err := parseYAMLScalar(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.number, err)
+		}
+		items = append(items, v)
+		p.pos++
+	}
+
+	return items, nil
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func splitYAMLKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") || strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return "", "", false
+	}
+
+	key, value, ok := strings.Cut(text, ": ")
+	if !ok {
+		key, ok = strings.CutSuffix(text, ":")
+	}
+	if !ok || key == "" || strings.ContainsAny(key, "#\"'") {
+		return "", "", false
+	}
+
+	return strings.TrimSpace(key), strings.TrimSpace(value), true
+}
+
+func parseYAMLScalar(value string) (any, error) {
+	switch {
+	case value == "~" || value == "null":
+		return nil, nil
+	case strings.HasPrefix(value, "\""):
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double-quoted string %s", value)
+		}
+		return s, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return nil, fmt.Errorf("invalid single-quoted string %s", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	case strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{"):
+		var v any
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, fmt.Errorf("unsupported flow collection %s", value)
+		}
+		return v, nil
+	case strings.ContainsAny(value[:1], "&*!|>%@`"):
+		return nil, fmt.Errorf("unsupported YAML syntax %s", value)
+	}
+
+	return value, nil
+}
+
+// stripYAMLComment removes a trailing comment outside of quoted scalars. A
+// quote only opens a scalar at the start of a value, so apostrophes inside
+// plain scalars are left alone.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && startsYAMLValue(line[:i]):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+func startsYAMLValue(before string) bool {
+	before = strings.TrimRight(before, " ")
+	return before == "" || strings.ContainsAny(before[len(before)-1:], ":-[{,")
+}